@@ -10,15 +10,21 @@ import (
 	"net/http"
 	"sync"
 	"syscall"
+	"time"
 
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/oklog/run"
 	"github.com/pingcap/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/stonejianbu/csweb/pkg/csweb-utils"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -26,10 +32,12 @@ var once sync.Once
 var app *App
 
 type App struct {
-	Name  string
-	Addr  string
-	opts  *Options
-	Serve ServeInterface
+	Name       string
+	Addr       string
+	opts       *Options
+	Serve      ServeInterface
+	registry   *prometheus.Registry
+	srvMetrics *grpcprom.ServerMetrics
 }
 
 // NewApp new an app with name
@@ -39,11 +47,26 @@ func NewApp(name string, options ...ServeOptions) *App {
 		for _, serveOpt := range options {
 			serveOpt(opts)
 		}
-		app = &App{opts: opts, Name: name}
+		app = &App{
+			opts:       opts,
+			Name:       name,
+			registry:   csweb_utils.NewMetricsRegistry(),
+			srvMetrics: csweb_utils.NewServerMetrics(opts.MetricsBuckets),
+		}
 	})
 	return app
 }
 
+// Meter 服务自定义的OpenTelemetry指标，通过WithMeterConfig配置的Prometheus/OTLP导出
+func (that *App) Meter(opts ...metric.MeterOption) metric.Meter {
+	return otel.Meter(that.Name, opts...)
+}
+
+// RegisterCollectors 注册服务自定义的Prometheus指标，与框架的指标一起在/metrics暴露
+func (that *App) RegisterCollectors(cs ...prometheus.Collector) error {
+	return csweb_utils.RegisterCollectors(that.registry, cs...)
+}
+
 // InitServe init the http serve and grpc serve
 func (that *App) InitServe(s ServeInterface) {
 	that.Serve = s
@@ -56,21 +79,48 @@ func (that *App) Run(addr string) error {
 	}
 	g := &run.Group{}
 	// init trace
-	if err := csweb_utils.InitTracerProvider(that.Name, that.opts.TraceAddr); err != nil {
-		logrus.Error(err)
+	shutdownTracer, err := csweb_utils.InitTracer(that.Name, that.tracerConfig())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 导出剩余的span
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracer(ctx); err != nil {
+			logrus.Errorf("shutdown tracer provider failed: %v", err)
+		}
+	}()
+	// init meter
+	shutdownMeter, err := csweb_utils.InitMeter(that.Name, that.opts.MeterConfig, that.registry)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownMeter(ctx); err != nil {
+			logrus.Errorf("shutdown meter provider failed: %v", err)
+		}
+	}()
 	// grpc server
 	logrus.Infof("start to launch grpc server, listen at %s", that.Addr)
-	that.startGrpcServer(g)
+	if err := that.startGrpcServer(g); err != nil {
+		return err
+	}
 	// gateway server
 	if len(that.opts.Gateway) > 0 {
 		logrus.Infof("start to launch http server, listen at %s", that.opts.Gateway)
-		that.startHttpServer(g)
+		if err := that.startHttpServer(g); err != nil {
+			return err
+		}
 	}
 	// metrics server
 	if len(that.opts.MetricsAddr) > 0 {
 		logrus.Infof("start to launch http metrics server, listen at %s", that.opts.MetricsAddr)
-		that.startMetricsServer(g)
+		if err := that.startMetricsServer(g); err != nil {
+			return err
+		}
 	}
 	// add signal handler
 	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
@@ -81,67 +131,208 @@ func (that *App) Run(addr string) error {
 	return nil
 }
 
-func (that *App) startHttpServer(g *run.Group) {
+// tracerConfig 未指定TracerConfig时，设置了TraceAddr则导出到zipkin，否则不导出
+func (that *App) tracerConfig() csweb_utils.TracerConfig {
+	if that.opts.TracerConfig != nil {
+		return *that.opts.TracerConfig
+	}
+	if len(that.opts.TraceAddr) > 0 {
+		return csweb_utils.TracerConfig{Exporter: csweb_utils.TraceExporterZipkin, Endpoint: that.opts.TraceAddr}
+	}
+	return csweb_utils.TracerConfig{Exporter: csweb_utils.TraceExporterNone}
+}
+
+func (that *App) startHttpServer(g *run.Group) error {
 	gatewayHttp := &http.Server{Addr: that.opts.Gateway}
+	transportCreds := insecure.NewCredentials() // disables transport security
+	if len(that.opts.TLSCertFile) > 0 {
+		// 默认不携带客户端证书，经过gateway的请求使用jwt/api key等凭证认证
+		tlsConf, err := csweb_utils.NewClientTLSConfig(that.opts.GatewayTLSCertFile, that.opts.GatewayTLSKeyFile, that.opts.GatewayTLSRootCA)
+		if err != nil {
+			return err
+		}
+		transportCreds = credentials.NewTLS(tlsConf)
+	}
 	g.Add(func() error {
 		mux := runtime.NewServeMux(
-			runtime.WithErrorHandler(csweb_utils.CustomErrorHandler), // 错误Handler统一处理响应格式
-			runtime.WithMetadata(csweb_utils.CookieToAuth("token")),  // 指定cookie的key的值转换为header Authorization的值
+			runtime.WithErrorHandler(csweb_utils.CustomErrorHandler),          // 错误Handler统一处理响应格式
+			runtime.WithMetadata(csweb_utils.CookieToAuth(that.authCookie())), // 指定cookie的key的值转换为header Authorization的值
+			runtime.WithMetadata(csweb_utils.TraceRoute),                      // 以路由模板作为span名称
+			runtime.WithMetadata(csweb_utils.MetricsRoute),                    // 以路由模板作为指标的route标签
 			runtime.WithOutgoingHeaderMatcher(func(key string) (string, bool) { // grpc设置的header透传出去，而不添加前缀Grpc-Metadata-
 				return key, true
 			}),
+			runtime.WithIncomingHeaderMatcher(csweb_utils.IncomingHeaderMatcher), // 透传x-api-key等header到grpc metadata
 		)
 		dialOpts := []grpc.DialOption{
-			grpc.WithTransportCredentials(insecure.NewCredentials()), // disables transport security
-			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),       // trace
+			grpc.WithTransportCredentials(transportCreds),      // tls or insecure
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()), // trace
 		}
 		if err := that.Serve.HTTPServe(mux, dialOpts); err != nil {
 			return err
 		}
-		gatewayHttp.Handler = csweb_utils.WithTrace(mux)
+		// oidc login
+		if that.opts.OIDC != nil {
+			if len(that.opts.JwtSignKey) == 0 {
+				return errors.New("oidc login requires jwt auth, please call WithJwtAuth")
+			}
+			oidcHandler, err := csweb_utils.NewOIDCHandler(context.Background(), *that.opts.OIDC, that.newJWT())
+			if err != nil {
+				return err
+			}
+			if err := oidcHandler.Register(mux); err != nil {
+				return err
+			}
+		}
+		var handler http.Handler = mux
+		// csrf
+		if that.opts.CSRF {
+			csrfKey := that.opts.CSRFKey
+			if len(csrfKey) == 0 {
+				csrfKey = that.opts.JwtSignKey
+			}
+			// 空密钥签发的token可被伪造
+			if len(csrfKey) == 0 {
+				return errors.New("csrf requires a signing key, please call WithCSRFKey or WithJwtAuth")
+			}
+			csrfConf := csweb_utils.CSRFConfig{
+				Key:         []byte(csrfKey),
+				ExemptPaths: that.opts.CSRFExemptPaths,
+				AuthCookie:  that.authCookie(),
+			}
+			if that.opts.OIDC != nil {
+				csrfConf.InsecureCookie = that.opts.OIDC.InsecureCookie
+			}
+			handler = csweb_utils.WithCSRF(handler, csrfConf)
+		}
+		// metrics
+		if len(that.opts.MetricsAddr) > 0 {
+			handler = csweb_utils.WithHTTPMetrics(handler)
+		}
+		gatewayHttp.Handler = csweb_utils.WithTrace(handler)
 		return gatewayHttp.ListenAndServe()
 	}, func(err error) {
 		if err := gatewayHttp.Close(); err != nil {
 			logrus.Errorf("failed to stop web server, err: %v", err)
 		}
 	})
+	return nil
+}
+
+// authCookie 网关读取jwt的cookie，启用oidc登录时与其签发的cookie一致，默认 token
+func (that *App) authCookie() string {
+	if that.opts.OIDC != nil && len(that.opts.OIDC.CookieName) > 0 {
+		return that.opts.OIDC.CookieName
+	}
+	return "token"
 }
 
-func (that *App) startGrpcServer(g *run.Group) {
+// newJWT jwt签发和校验配置
+func (that *App) newJWT() *csweb_utils.JWT {
+	return &csweb_utils.JWT{
+		SigningKey: []byte(that.opts.JwtSignKey),
+		Issuer:     that.opts.JwtIssuer,
+		Audience:   that.opts.JwtAudience,
+		Leeway:     that.opts.JwtLeeway,
+	}
+}
+
+func (that *App) startGrpcServer(g *run.Group) error {
 	usi := make([]grpc.UnaryServerInterceptor, 0)
 	// logger
 	usi = append(usi, csweb_utils.WithLogger())
-	// ratelimit
+	// i18n, localize errors returned by the following interceptors and handlers
+	if that.opts.I18nCatalog != nil {
+		usi = append(usi, csweb_utils.WithI18n(that.opts.I18nCatalog))
+	}
+	// auth: jwt / api key / mtls
+	authPolicy := csweb_utils.NewAuthPolicy(that.opts.AuthStrict, that.opts.authFilterMethods...)
+	authenticators := make([]csweb_utils.Authenticator, 0)
+	if len(that.opts.JwtSignKey) > 0 {
+		authenticators = append(authenticators, csweb_utils.NewJwtAuthenticator(that.newJWT()))
+	}
+	if that.opts.APIKeyStore != nil {
+		authenticators = append(authenticators, csweb_utils.NewAPIKeyAuthenticator(that.opts.APIKeyStore))
+	}
+	if that.opts.CertMapper != nil {
+		authenticators = append(authenticators, csweb_utils.NewMTLSAuthenticator(that.opts.CertMapper))
+	}
+	if len(authenticators) > 0 {
+		usi = append(usi, csweb_utils.WithAuth(authPolicy, authenticators...))
+	}
+	// priority classification, after auth so that jwt claims are available and before all load shedding
+	if that.opts.PriorityEnabled {
+		usi = append(usi, csweb_utils.WithPriority(that.opts.PriorityClassifiers...))
+	}
+	// ratelimit, after priority so that high priority requests keep reserved tokens
 	if that.opts.RateLimit != 0 {
 		usi = append(usi, csweb_utils.WithRateLimit(that.opts.RateLimit))
 	}
-	// jwt auth
-	if len(that.opts.JwtSignKey) > 0 {
-		usi = append(usi, csweb_utils.WithJwtAuth(that.opts.JwtSignKey, that.opts.authFilterMethods...))
+	// ratelimit by rules, after auth so that the caller identity is available
+	if len(that.opts.RateLimitRules) > 0 {
+		usi = append(usi, csweb_utils.WithRateLimitRules(that.opts.RateLimitRules...))
+	}
+	// concurrency limit / adaptive load shedding
+	if that.opts.ConcurrencyLimit != nil {
+		usi = append(usi, csweb_utils.WithConcurrencyLimit(that.opts.ConcurrencyLimit))
+	}
+	// quota, after load shedding so that rejected requests are not counted
+	if that.opts.QuotaStore != nil && len(that.opts.QuotaRules) > 0 {
+		usi = append(usi, csweb_utils.WithQuota(that.opts.QuotaStore, that.opts.QuotaRules...))
 	}
 	// metrics intercept
-	usi = append(usi, csweb_utils.WithMetrics())
+	usi = append(usi, csweb_utils.WithServerMetrics(that.srvMetrics))
 	// proto validator
-	usi = append(usi, csweb_utils.WithValidator())
+	validatorOpts := that.opts.ValidatorOptions
+	if that.opts.DevMode {
+		validatorOpts = append(validatorOpts, csweb_utils.ValidateResponse())
+	}
+	validator, err := csweb_utils.NewValidator(validatorOpts...)
+	if err != nil {
+		return err
+	}
+	usi = append(usi, validator.UnaryServerInterceptor())
 	// recover intercept
 	usi = append(usi, csweb_utils.WithRecovery())
-	// new grpc server instance
-	grpcServer := grpc.NewServer(
-		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()), // trace和rpc.server.*指标
 		grpc.ChainUnaryInterceptor(usi...),
 		grpc.ChainStreamInterceptor(),
-	)
+	}
+	// tls
+	if len(that.opts.TLSCertFile) > 0 {
+		tlsConf, err := csweb_utils.NewServerTLSConfig(that.opts.TLSCertFile, that.opts.TLSKeyFile, that.opts.TLSClientCAFile)
+		if err != nil {
+			return err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+	if that.opts.QuotaAdmin && (that.opts.QuotaStore == nil || len(authenticators) == 0) {
+		return errors.New("quota admin requires WithQuota and an authenticator")
+	}
+	// new grpc server instance
+	grpcServer := grpc.NewServer(serverOpts...)
 	// async start grpc server
 	g.Add(func() error {
 		// register grpc server
 		if err := that.Serve.GRPCServe(grpcServer); err != nil {
 			return err
 		}
-		csweb_utils.SrvMetrics.InitializeMetrics(grpcServer)
+		// quota admin service
+		if that.opts.QuotaAdmin {
+			csweb_utils.RegisterQuotaAdmin(grpcServer, that.opts.QuotaStore, that.opts.QuotaRules...)
+		}
+		// load auth policy from proto options
+		if len(authenticators) > 0 {
+			if err := authPolicy.Load(grpcServer.GetServiceInfo()); err != nil {
+				return err
+			}
+		}
+		that.srvMetrics.InitializeMetrics(grpcServer)
 		// start to listen
 		listen, err := net.Listen("tcp", that.Addr)
 		if err != nil {
-			logrus.Error("net.Listen failed, err: %v", err)
+			logrus.Errorf("net.Listen failed, err: %v", err)
 			return err
 		}
 		if err := grpcServer.Serve(listen); err != nil {
@@ -152,18 +343,23 @@ func (that *App) startGrpcServer(g *run.Group) {
 		grpcServer.GracefulStop()
 		return
 	})
+	return nil
 }
 
-func (that *App) startMetricsServer(g *run.Group) {
+func (that *App) startMetricsServer(g *run.Group) error {
+	if err := csweb_utils.RegisterMetrics(that.registry, that.srvMetrics); err != nil {
+		return err
+	}
 	httpSrv := &http.Server{Addr: that.opts.MetricsAddr}
 	g.Add(func() error {
 		m := http.NewServeMux()
-		csweb_utils.InitMetrics()
-		m.Handle("/metrics", promhttp.Handler())
+		m.Handle("/metrics", promhttp.HandlerFor(that.registry, promhttp.HandlerOpts{
+			EnableOpenMetrics: true, // exemplar中携带traceId
+		}))
 		httpSrv.Handler = m
 		return httpSrv.ListenAndServe()
 	}, func(err error) {
 		_ = httpSrv.Close()
 	})
-
+	return nil
 }
@@ -4,24 +4,125 @@
 
 package csweb
 
+import (
+	"time"
+
+	"github.com/stonejianbu/csweb/pkg/csweb-utils"
+)
+
 type Options struct {
-	Gateway           string
-	TraceAddr         string
-	EnableMetrics     bool
-	MetricsAddr       string
-	JwtSignKey        string
-	authFilterMethods []string
-	RateLimit         int
+	Gateway             string
+	TraceAddr           string
+	TracerConfig        *csweb_utils.TracerConfig
+	MeterConfig         csweb_utils.MeterConfig
+	EnableMetrics       bool
+	MetricsAddr         string
+	MetricsBuckets      []float64
+	JwtSignKey          string
+	JwtIssuer           string
+	JwtAudience         []string
+	JwtLeeway           time.Duration
+	authFilterMethods   []string
+	AuthStrict          bool
+	APIKeyStore         csweb_utils.APIKeyStore
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSClientCAFile     string
+	GatewayTLSCertFile  string
+	GatewayTLSKeyFile   string
+	GatewayTLSRootCA    string
+	CertMapper          csweb_utils.CertPrincipalMapper
+	OIDC                *csweb_utils.OIDCConfig
+	CSRF                bool
+	CSRFExemptPaths     []string
+	CSRFKey             string
+	RateLimit           int
+	RateLimitRules      []csweb_utils.RateLimitRule
+	ConcurrencyLimit    func() csweb_utils.ConcurrencyLimit
+	PriorityEnabled     bool
+	PriorityClassifiers []csweb_utils.PriorityClassifier
+	QuotaStore          csweb_utils.QuotaStore
+	QuotaRules          []csweb_utils.QuotaRule
+	QuotaAdmin          bool
+	I18nCatalog         *csweb_utils.Catalog
+	ValidatorOptions    []csweb_utils.ValidatorOption
+	DevMode             bool
 }
 
 type ServeOptions func(opts *Options)
 
+// WithRateLimit 限制每秒处理的请求数，num同时作为允许的突发请求数。
+// 不兼容变更: 之前num只是突发容量，每秒只补充1个令牌，需要旧行为时使用 WithRateLimitRules 分别设置Rate和Burst
 func WithRateLimit(num int) ServeOptions {
 	return func(opts *Options) {
 		opts.RateLimit = num
 	}
 }
 
+// WithRateLimitRules 按方法、调用方(UID/IP/header)等维度限流，每个key拥有独立的令牌桶
+func WithRateLimitRules(rules ...csweb_utils.RateLimitRule) ServeOptions {
+	return func(opts *Options) {
+		opts.RateLimitRules = append(opts.RateLimitRules, rules...)
+	}
+}
+
+// WithConcurrencyLimit 限制每个方法同时处理的请求数，newLimit为每个方法创建上限策略，
+// 如 csweb_utils.NewFixedLimit / NewAIMDLimit / NewGradientLimit
+func WithConcurrencyLimit(newLimit func() csweb_utils.ConcurrencyLimit) ServeOptions {
+	return func(opts *Options) {
+		opts.ConcurrencyLimit = newLimit
+	}
+}
+
+// WithPriority 按优先级处理过载，classifiers依次判断请求的优先级(header/jwt claim/方法注解)，
+// 并发限制和限流为高优先级的请求保留容量，低优先级的请求先被拒绝；
+// PriorityFromHeader 只允许PriorityTrust中的调用方请求高于normal的优先级
+func WithPriority(classifiers ...csweb_utils.PriorityClassifier) ServeOptions {
+	return func(opts *Options) {
+		opts.PriorityEnabled = true
+		opts.PriorityClassifiers = append(opts.PriorityClassifiers, classifiers...)
+	}
+}
+
+// WithQuota 按租户或api key统计每天/每月的调用次数，用量持久化在store中，超过配额的请求被拒绝
+func WithQuota(store csweb_utils.QuotaStore, rules ...csweb_utils.QuotaRule) ServeOptions {
+	return func(opts *Options) {
+		opts.QuotaStore = store
+		opts.QuotaRules = append(opts.QuotaRules, rules...)
+	}
+}
+
+// WithQuotaAdmin 注册配额管理服务 csweb.QuotaAdmin，仅admin角色可调用，需启用鉴权
+func WithQuotaAdmin() ServeOptions {
+	return func(opts *Options) {
+		opts.QuotaAdmin = true
+	}
+}
+
+// WithI18n 根据Accept-Language为错误添加翻译后的LocalizedMessage，catalog为nil时使用内置的中英文消息
+func WithI18n(catalog *csweb_utils.Catalog) ServeOptions {
+	return func(opts *Options) {
+		if catalog == nil {
+			catalog = csweb_utils.DefaultCatalog
+		}
+		opts.I18nCatalog = catalog
+	}
+}
+
+// WithValidatorOptions 配置请求校验，如 csweb_utils.ValidateFor 注册自定义校验函数
+func WithValidatorOptions(validatorOpts ...csweb_utils.ValidatorOption) ServeOptions {
+	return func(opts *Options) {
+		opts.ValidatorOptions = append(opts.ValidatorOptions, validatorOpts...)
+	}
+}
+
+// WithDevMode 开发模式，额外校验响应消息，不建议在生产环境开启
+func WithDevMode() ServeOptions {
+	return func(opts *Options) {
+		opts.DevMode = true
+	}
+}
+
 func WithJwtAuth(signKey string, authFilterMethods ...string) ServeOptions {
 	return func(opts *Options) {
 		opts.JwtSignKey = signKey
@@ -29,6 +130,91 @@ func WithJwtAuth(signKey string, authFilterMethods ...string) ServeOptions {
 	}
 }
 
+// WithJwtIssuer 签发token时写入iss，并校验token的iss
+func WithJwtIssuer(issuer string) ServeOptions {
+	return func(opts *Options) {
+		opts.JwtIssuer = issuer
+	}
+}
+
+// WithJwtAudience 签发token时写入aud，并校验token的aud包含其中之一
+func WithJwtAudience(audience ...string) ServeOptions {
+	return func(opts *Options) {
+		opts.JwtAudience = audience
+	}
+}
+
+// WithJwtLeeway 校验token的exp/nbf/iat时允许的时钟偏差
+func WithJwtLeeway(leeway time.Duration) ServeOptions {
+	return func(opts *Options) {
+		opts.JwtLeeway = leeway
+	}
+}
+
+// WithAPIKeyAuth 启用x-api-key认证，可与WithJwtAuth同时使用，任一凭证通过即可
+func WithAPIKeyAuth(store csweb_utils.APIKeyStore) ServeOptions {
+	return func(opts *Options) {
+		opts.APIKeyStore = store
+	}
+}
+
+// WithTLS grpc服务启用tls，clientCAFile非空时校验客户端证书(mTLS)，gateway的tls配置见WithGatewayTLS
+func WithTLS(certFile, keyFile, clientCAFile string) ServeOptions {
+	return func(opts *Options) {
+		opts.TLSCertFile = certFile
+		opts.TLSKeyFile = keyFile
+		opts.TLSClientCAFile = clientCAFile
+	}
+}
+
+// WithGatewayTLS gateway访问tls的grpc服务时使用的配置: rootCAFile为签发服务端证书的CA(为空时使用系统CA)，
+// certFile为gateway的客户端证书(可为空)。gateway的证书会被WithMTLSAuth认证为同一个调用方，
+// 所有经过gateway的请求都将获得该身份，因此不要在CertPrincipalMapper中为其映射角色
+func WithGatewayTLS(certFile, keyFile, rootCAFile string) ServeOptions {
+	return func(opts *Options) {
+		opts.GatewayTLSCertFile = certFile
+		opts.GatewayTLSKeyFile = keyFile
+		opts.GatewayTLSRootCA = rootCAFile
+	}
+}
+
+// WithMTLSAuth 启用客户端证书认证，需配合WithTLS指定clientCAFile
+func WithMTLSAuth(mapper csweb_utils.CertPrincipalMapper) ServeOptions {
+	return func(opts *Options) {
+		opts.CertMapper = mapper
+	}
+}
+
+// WithOIDC 网关启用OIDC授权码登录，登录成功后使用WithJwtAuth的密钥签发token并写入cookie
+func WithOIDC(conf csweb_utils.OIDCConfig) ServeOptions {
+	return func(opts *Options) {
+		opts.OIDC = &conf
+	}
+}
+
+// WithCSRF 网关对通过cookie认证的非安全方法请求启用CSRF校验，exemptPaths支持path.Match通配符，
+// 前端通过 GET /auth/csrf 获取token并在 X-CSRF-Token header中携带
+func WithCSRF(exemptPaths ...string) ServeOptions {
+	return func(opts *Options) {
+		opts.CSRF = true
+		opts.CSRFExemptPaths = exemptPaths
+	}
+}
+
+// WithCSRFKey 指定CSRF token的签名密钥，未指定时使用WithJwtAuth的密钥
+func WithCSRFKey(key string) ServeOptions {
+	return func(opts *Options) {
+		opts.CSRFKey = key
+	}
+}
+
+// WithStrictAuth 要求所有已注册的方法都声明 (csweb.auth) 注解，否则启动失败
+func WithStrictAuth() ServeOptions {
+	return func(opts *Options) {
+		opts.AuthStrict = true
+	}
+}
+
 func WithMetrics(addr string) ServeOptions {
 	return func(opts *Options) {
 		opts.MetricsAddr = addr
@@ -41,6 +227,27 @@ func WithTracer(addr string) ServeOptions {
 	}
 }
 
+// WithTracerConfig 指定链路追踪的导出方式(zipkin/otlpgrpc/otlphttp/stdout/none)和采样策略，优先于WithTracer
+func WithTracerConfig(conf csweb_utils.TracerConfig) ServeOptions {
+	return func(opts *Options) {
+		opts.TracerConfig = &conf
+	}
+}
+
+// WithMetricsBuckets 指定grpc请求耗时直方图的分桶(秒)，默认为csweb_utils.DefaultHandlingTimeBuckets
+func WithMetricsBuckets(buckets ...float64) ServeOptions {
+	return func(opts *Options) {
+		opts.MetricsBuckets = buckets
+	}
+}
+
+// WithMeterConfig 启用OpenTelemetry指标，Prometheus为true时在App的/metrics暴露，Exporter指定OTLP推送方式
+func WithMeterConfig(conf csweb_utils.MeterConfig) ServeOptions {
+	return func(opts *Options) {
+		opts.MeterConfig = conf
+	}
+}
+
 // WithGateway enable the gateway and specify the address
 func WithGateway(addr string) ServeOptions {
 	return func(opts *Options) {
@@ -0,0 +1,176 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// APIKeyHeader api key所在的metadata/header
+const APIKeyHeader = "x-api-key"
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	APIKeyInvalid     = status.Error(codes.Unauthenticated, "invalid api key")
+)
+
+// APIKey 服务调用方的api key，只保存key的sha256哈希值
+type APIKey struct {
+	Name        string     `json:"name" gorm:"size:64"`
+	KeyHash     string     `json:"keyHash" gorm:"size:64;uniqueIndex"`
+	Roles       []string   `json:"roles" gorm:"serializer:json"`
+	Disabled    bool       `json:"disabled"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	*gorm.Model `json:"-"`
+}
+
+// Valid api key是否可用
+func (k *APIKey) Valid() bool {
+	if k.Disabled {
+		return false
+	}
+	return k.ExpiresAt == nil || k.ExpiresAt.After(time.Now())
+}
+
+// APIKeyStore api key存储，按哈希值查找，未找到时返回 ErrAPIKeyNotFound
+type APIKeyStore interface {
+	GetByHash(ctx context.Context, keyHash string) (*APIKey, error)
+}
+
+// HashAPIKey 计算api key的哈希值
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey 生成一个随机的api key，返回明文(仅交给调用方)及其哈希值(用于保存)
+func GenerateAPIKey() (key string, keyHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = hex.EncodeToString(buf)
+	return key, HashAPIKey(key), nil
+}
+
+// StaticAPIKeyStore 静态的api key存储
+type StaticAPIKeyStore struct {
+	keys map[string]*APIKey
+}
+
+// NewFileAPIKeyStore 从json文件加载api key，文件内容为 APIKey 数组
+func NewFileAPIKeyStore(path string) (*StaticAPIKeyStore, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*APIKey, 0)
+	if err := json.Unmarshal(buf, &keys); err != nil {
+		return nil, err
+	}
+	return NewStaticAPIKeyStore(keys...), nil
+}
+
+// NewStaticAPIKeyStore 使用给定的api key创建存储
+func NewStaticAPIKeyStore(keys ...*APIKey) *StaticAPIKeyStore {
+	store := &StaticAPIKeyStore{keys: make(map[string]*APIKey, len(keys))}
+	for _, k := range keys {
+		store.keys[k.KeyHash] = k
+	}
+	return store
+}
+
+func (that *StaticAPIKeyStore) GetByHash(_ context.Context, keyHash string) (*APIKey, error) {
+	k, ok := that.keys[keyHash]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return k, nil
+}
+
+// GormAPIKeyStore 从数据库加载api key
+type GormAPIKeyStore struct {
+	db *gorm.DB
+}
+
+func NewGormAPIKeyStore(db *gorm.DB) *GormAPIKeyStore {
+	return &GormAPIKeyStore{db: db}
+}
+
+// AutoMigrate 创建api key表
+func (that *GormAPIKeyStore) AutoMigrate() error {
+	return that.db.AutoMigrate(&APIKey{})
+}
+
+func (that *GormAPIKeyStore) GetByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	k := &APIKey{}
+	err := CurrentDB(ctx, that.db).WithContext(ctx).First(k, "key_hash = ?", keyHash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// IncomingHeaderMatcher 在grpc-gateway默认规则的基础上将 X-Api-Key 透传到grpc metadata
+func IncomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, APIKeyHeader) {
+		return APIKeyHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// APIKeyAuthenticator 通过 x-api-key 认证机器调用方
+type APIKeyAuthenticator struct {
+	store APIKeyStore
+}
+
+func NewAPIKeyAuthenticator(store APIKeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store}
+}
+
+func (that *APIKeyAuthenticator) Kind() string {
+	return CredentialAPIKey
+}
+
+func (that *APIKeyAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	values := metadata.ValueFromIncomingContext(ctx, APIKeyHeader)
+	if len(values) == 0 || len(values[0]) == 0 {
+		return ctx, ErrNoCredential
+	}
+	k, err := that.store.GetByHash(ctx, HashAPIKey(values[0]))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return ctx, APIKeyInvalid
+	}
+	if err != nil {
+		LogWithContext(ctx).Errorf("get api key failed, err: %v", err)
+		return ctx, status.Error(codes.Unavailable, "api key store unavailable")
+	}
+	if !k.Valid() {
+		return ctx, APIKeyInvalid
+	}
+	return SetPrincipalWithContext(ctx, &Principal{
+		Kind:  CredentialAPIKey,
+		Id:    k.Name,
+		Name:  k.Name,
+		Roles: k.Roles,
+	}), nil
+}
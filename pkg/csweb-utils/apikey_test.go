@@ -0,0 +1,118 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestWithAuth_APIKeyAndJwt(t *testing.T) {
+	key, keyHash, err := GenerateAPIKey()
+	assert.Nil(t, err)
+	expired := time.Now().Add(-time.Hour)
+	expiredKey, expiredHash, _ := GenerateAPIKey()
+	store := NewStaticAPIKeyStore(
+		&APIKey{Name: "billing", KeyHash: keyHash, Roles: []string{"service"}},
+		&APIKey{Name: "legacy", KeyHash: expiredHash, ExpiresAt: &expired},
+	)
+	jwtObj := NewJWT("hello")
+	token, err := jwtObj.CreateToken(CustomClaims{UID: "1", Username: "stone", AuthorityId: "user"})
+	assert.Nil(t, err)
+
+	interceptor := WithAuth(NewAuthPolicy(false), NewJwtAuthenticator(jwtObj), NewAPIKeyAuthenticator(store))
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Get"}
+	call := func(md metadata.MD) (*Principal, error) {
+		var principal *Principal
+		_, err := interceptor(metadata.NewIncomingContext(context.Background(), md), nil, info, func(ctx context.Context, req any) (any, error) {
+			principal, _ = PrincipalFromContext(ctx)
+			return nil, nil
+		})
+		return principal, err
+	}
+
+	principal, err := call(metadata.Pairs(APIKeyHeader, key))
+	assert.Nil(t, err)
+	assert.Equal(t, CredentialAPIKey, principal.Kind)
+	assert.Equal(t, "billing", principal.Name)
+
+	principal, err = call(metadata.Pairs("authorization", fmt.Sprintf("bearer %s", token)))
+	assert.Nil(t, err)
+	assert.Equal(t, CredentialJwt, principal.Kind)
+	assert.Equal(t, "stone", principal.Name)
+
+	_, err = call(metadata.Pairs(APIKeyHeader, "wrong"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = call(metadata.Pairs(APIKeyHeader, expiredKey))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = call(metadata.MD{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// newSqliteDB 测试用的sqlite数据库
+func newSqliteDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	assert.Nil(t, err)
+	return db
+}
+
+func TestNewFileAPIKeyStore(t *testing.T) {
+	key, keyHash, err := GenerateAPIKey()
+	assert.Nil(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+	content := fmt.Sprintf(`[{"name": "billing", "keyHash": %q, "roles": ["service"]}, {"name": "plain", "keyHash": "not-a-hash"}]`, keyHash)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+
+	store, err := NewFileAPIKeyStore(path)
+	assert.Nil(t, err)
+	k, err := store.GetByHash(context.Background(), HashAPIKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, "billing", k.Name)
+	assert.Equal(t, []string{"service"}, k.Roles)
+	// 文件中保存的是哈希值，明文不能直接作为哈希查找
+	_, err = store.GetByHash(context.Background(), key)
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+	badPath := filepath.Join(dir, "bad.json")
+	assert.Nil(t, os.WriteFile(badPath, []byte(`{"name": "billing"`), 0o600))
+	_, err = NewFileAPIKeyStore(badPath)
+	assert.NotNil(t, err)
+	_, err = NewFileAPIKeyStore(filepath.Join(dir, "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestGormAPIKeyStore(t *testing.T) {
+	db := newSqliteDB(t)
+	store := NewGormAPIKeyStore(db)
+	assert.Nil(t, store.AutoMigrate())
+	key, keyHash, err := GenerateAPIKey()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Create(&APIKey{Name: "billing", KeyHash: keyHash, Roles: []string{"service", "admin"}, Model: &gorm.Model{}}).Error)
+
+	k, err := store.GetByHash(context.Background(), HashAPIKey(key))
+	assert.Nil(t, err)
+	assert.Equal(t, "billing", k.Name)
+	assert.Equal(t, []string{"service", "admin"}, k.Roles)
+	assert.True(t, k.Valid())
+
+	_, err = store.GetByHash(context.Background(), HashAPIKey("unknown"))
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+}
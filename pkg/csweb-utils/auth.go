@@ -0,0 +1,182 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// DefaultAuthExemptServices 默认免鉴权且无需声明 (csweb.auth) 的服务: 健康检查和反射
+var DefaultAuthExemptServices = []string{
+	"grpc.health.v1.Health",
+	"grpc.reflection.v1.ServerReflection",
+	"grpc.reflection.v1alpha.ServerReflection",
+}
+
+// AuthPolicy 基于proto注解 (csweb.auth) 的方法鉴权策略
+type AuthPolicy struct {
+	strict        bool
+	filterMethods []string
+	rules         map[string]*csweb.AuthRule
+}
+
+// NewAuthPolicy 创建鉴权策略，strict为true时已注册的方法必须声明 (csweb.auth)，
+// filterMethods 为兼容旧用法的免鉴权方法列表
+func NewAuthPolicy(strict bool, filterMethods ...string) *AuthPolicy {
+	return &AuthPolicy{
+		strict:        strict,
+		filterMethods: filterMethods,
+		rules:         make(map[string]*csweb.AuthRule),
+	}
+}
+
+// Load 通过protoregistry读取已注册服务各方法的 (csweb.auth) 注解，需在grpc服务注册之后、启动之前调用
+func (that *AuthPolicy) Load(services map[string]grpc.ServiceInfo) error {
+	missing := make([]string, 0)
+	for name, info := range services {
+		if slices.Contains(DefaultAuthExemptServices, name) {
+			continue
+		}
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			if that.strict {
+				return fmt.Errorf("auth policy: service %s not found in proto registry", name)
+			}
+			continue
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		for _, m := range info.Methods {
+			fullMethod := fmt.Sprintf("/%s/%s", name, m.Name)
+			md := sd.Methods().ByName(protoreflect.Name(m.Name))
+			if md != nil && proto.HasExtension(md.Options(), csweb.E_Auth) {
+				that.rules[fullMethod] = proto.GetExtension(md.Options(), csweb.E_Auth).(*csweb.AuthRule)
+				continue
+			}
+			if !slices.Contains(that.filterMethods, fullMethod) {
+				missing = append(missing, fullMethod)
+			}
+		}
+	}
+	if that.strict && len(missing) > 0 {
+		slices.Sort(missing)
+		return fmt.Errorf("auth policy: methods without (csweb.auth) option: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// IsPublic 方法是否无需鉴权，DefaultAuthExemptServices中的服务均无需鉴权
+func (that *AuthPolicy) IsPublic(fullMethod string) bool {
+	if slices.Contains(that.filterMethods, fullMethod) {
+		return true
+	}
+	if service, _, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/"); ok && slices.Contains(DefaultAuthExemptServices, service) {
+		return true
+	}
+	rule, ok := that.rules[fullMethod]
+	return ok && rule.GetPublic()
+}
+
+// Allow 校验角色是否满足方法声明的roles，未声明roles时均允许
+func (that *AuthPolicy) Allow(fullMethod string, roles ...string) bool {
+	rule, ok := that.rules[fullMethod]
+	if !ok || len(rule.GetRoles()) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(rule.GetRoles(), role) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptCredential 方法是否接受该类凭证，未声明credentials时均接受
+func (that *AuthPolicy) AcceptCredential(fullMethod string, kind string) bool {
+	rule, ok := that.rules[fullMethod]
+	if !ok || len(rule.GetCredentials()) == 0 {
+		return true
+	}
+	return slices.Contains(rule.GetCredentials(), kind)
+}
+
+const (
+	CredentialJwt    = "jwt"
+	CredentialAPIKey = "apikey"
+	CredentialMTLS   = "mtls"
+)
+
+// ErrNoCredential 请求未携带该类凭证
+var ErrNoCredential = errors.New("no credential")
+
+// Principal 已认证的调用方身份
+type Principal struct {
+	Kind  string // 凭证类型
+	Id    string
+	Name  string
+	Roles []string
+}
+
+type principalKey struct{}
+
+func SetPrincipalWithContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext 获取ctx中的调用方身份，未鉴权或免鉴权的方法返回false
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// Authenticator 从请求中识别调用方身份
+type Authenticator interface {
+	// Kind 凭证类型，与 (csweb.auth).credentials 对应
+	Kind() string
+	// Authenticate 认证成功返回携带Principal的ctx，未携带该类凭证时返回 ErrNoCredential
+	Authenticate(ctx context.Context) (context.Context, error)
+}
+
+// WithAuth return a new unary server interceptor that authenticates the caller with the first
+// authenticator whose credential is present and accepted by the method, then authorizes it by the AuthPolicy.
+func WithAuth(policy *AuthPolicy, authenticators ...Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if policy.IsPublic(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		for _, a := range authenticators {
+			if !policy.AcceptCredential(info.FullMethod, a.Kind()) {
+				continue
+			}
+			authCtx, err := a.Authenticate(ctx)
+			if errors.Is(err, ErrNoCredential) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			principal, ok := PrincipalFromContext(authCtx)
+			if !ok || !policy.Allow(info.FullMethod, principal.Roles...) {
+				return nil, PermissionDeniedError()
+			}
+			return handler(authCtx, req)
+		}
+		return nil, status.Error(codes.Unauthenticated, "Request unauthenticated, missing credentials")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"testing"
+
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// registerAuthTestService 注册测试服务 csweb.authtest.Account: Login(public)、Admin(roles admin, apikey)、Plain(无注解)
+func registerAuthTestService(t *testing.T) {
+	const fileName = "csweb/authtest/account.proto"
+	if _, err := protoregistry.GlobalFiles.FindFileByPath(fileName); err == nil {
+		return
+	}
+	method := func(name string, rule *csweb.AuthRule) *descriptorpb.MethodDescriptorProto {
+		m := &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Empty"),
+			OutputType: proto.String(".google.protobuf.Empty"),
+		}
+		if rule != nil {
+			m.Options = &descriptorpb.MethodOptions{}
+			proto.SetExtension(m.Options, csweb.E_Auth, rule)
+		}
+		return m
+	}
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fileName),
+		Package:    proto.String("csweb.authtest"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/empty.proto", "csweb/options.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Account"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Login", &csweb.AuthRule{Public: true}),
+				method("Admin", &csweb.AuthRule{Roles: []string{"admin"}, Credentials: []string{CredentialAPIKey}}),
+				method("Plain", nil),
+			},
+		}},
+	}, protoregistry.GlobalFiles)
+	assert.Nil(t, err)
+	assert.Nil(t, protoregistry.GlobalFiles.RegisterFile(fd))
+}
+
+func authTestServices(services ...string) map[string]grpc.ServiceInfo {
+	all := map[string]grpc.ServiceInfo{
+		"csweb.authtest.Account": {Methods: []grpc.MethodInfo{{Name: "Login"}, {Name: "Admin"}, {Name: "Plain"}}},
+		"grpc.health.v1.Health":  {Methods: []grpc.MethodInfo{{Name: "Check"}, {Name: "Watch", IsServerStream: true}}},
+		"csweb.authtest.Unknown": {Methods: []grpc.MethodInfo{{Name: "Get"}}},
+	}
+	ret := make(map[string]grpc.ServiceInfo)
+	for _, name := range services {
+		ret[name] = all[name]
+	}
+	return ret
+}
+
+func TestAuthPolicy_Load(t *testing.T) {
+	registerAuthTestService(t)
+	tests := []struct {
+		name     string
+		strict   bool
+		filter   []string
+		services []string
+		wantErr  string
+	}{
+		{"non-strict ignores missing annotation", false, nil, []string{"csweb.authtest.Account", "csweb.authtest.Unknown"}, ""},
+		{"strict rejects missing annotation", true, nil, []string{"csweb.authtest.Account"}, "/csweb.authtest.Account/Plain"},
+		{"strict accepts filter methods", true, []string{"/csweb.authtest.Account/Plain"}, []string{"csweb.authtest.Account"}, ""},
+		{"strict rejects unknown service", true, nil, []string{"csweb.authtest.Unknown"}, "not found in proto registry"},
+		{"strict exempts health check", true, nil, []string{"grpc.health.v1.Health"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewAuthPolicy(tt.strict, tt.filter...).Load(authTestServices(tt.services...))
+			if len(tt.wantErr) == 0 {
+				assert.Nil(t, err)
+			} else if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthPolicy_Rules(t *testing.T) {
+	registerAuthTestService(t)
+	policy := NewAuthPolicy(false, "/csweb.authtest.Account/Legacy")
+	assert.Nil(t, policy.Load(authTestServices("csweb.authtest.Account")))
+
+	publicTests := []struct {
+		method string
+		want   bool
+	}{
+		{"/csweb.authtest.Account/Login", true},
+		{"/csweb.authtest.Account/Legacy", true},
+		{"/grpc.health.v1.Health/Check", true},
+		{"/csweb.authtest.Account/Admin", false},
+		{"/csweb.authtest.Account/Plain", false},
+	}
+	for _, tt := range publicTests {
+		assert.Equal(t, tt.want, policy.IsPublic(tt.method), tt.method)
+	}
+
+	allowTests := []struct {
+		method string
+		roles  []string
+		want   bool
+	}{
+		{"/csweb.authtest.Account/Admin", []string{"user", "admin"}, true},
+		{"/csweb.authtest.Account/Admin", []string{"user"}, false},
+		{"/csweb.authtest.Account/Admin", nil, false},
+		{"/csweb.authtest.Account/Plain", nil, true},
+	}
+	for _, tt := range allowTests {
+		assert.Equal(t, tt.want, policy.Allow(tt.method, tt.roles...), "%s %v", tt.method, tt.roles)
+	}
+
+	credentialTests := []struct {
+		method string
+		kind   string
+		want   bool
+	}{
+		{"/csweb.authtest.Account/Admin", CredentialAPIKey, true},
+		{"/csweb.authtest.Account/Admin", CredentialJwt, false},
+		{"/csweb.authtest.Account/Plain", CredentialJwt, true},
+	}
+	for _, tt := range credentialTests {
+		assert.Equal(t, tt.want, policy.AcceptCredential(tt.method, tt.kind), "%s %s", tt.method, tt.kind)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/bufbuild/protovalidate-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// maxCELExpandDepth 函数之间嵌套调用的最大展开层数，防止递归定义
+const maxCELExpandDepth = 8
+
+// CELLibrary 多个服务共享的CEL校验规则库:
+//   - Function 注册以CEL表达式定义的函数，proto注解中可直接调用，如
+//     (buf.validate.field).cel = {id: "tenant_id", message: "invalid tenant id", expression: "is_tenant_id(this)"}
+//   - Constraint 注册可复用的约束，proto注解中只需写id，如 (buf.validate.field).cel = {id: "cn_mobile"}
+//
+// protovalidate的CEL环境不支持扩展，函数在编译约束前以内联的方式展开，因此函数体只能使用protovalidate支持的CEL
+type CELLibrary struct {
+	mu          sync.RWMutex
+	functions   map[string]celFunction
+	constraints map[string]*validate.Constraint
+}
+
+type celFunction struct {
+	params []string
+	body   string
+}
+
+func NewCELLibrary() *CELLibrary {
+	return &CELLibrary{
+		functions:   make(map[string]celFunction),
+		constraints: make(map[string]*validate.Constraint),
+	}
+}
+
+// Function 注册函数，body中使用params引用参数，如 Function("is_slug", []string{"s"}, "s.matches('^[a-z0-9-]+$')")
+func (that *CELLibrary) Function(name string, params []string, body string) *CELLibrary {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.functions[name] = celFunction{params: params, body: body}
+	return that
+}
+
+// Constraint 注册可复用的约束，expression中可调用已注册的函数
+func (that *CELLibrary) Constraint(id, message, expression string) *CELLibrary {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.constraints[id] = &validate.Constraint{Id: id, Message: message, Expression: expression}
+	return that
+}
+
+// Expand 展开表达式中对已注册函数的调用
+func (that *CELLibrary) Expand(expr string) (string, error) {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	for depth := 0; depth < maxCELExpandDepth; depth++ {
+		expanded, changed, err := that.expandOnce(expr)
+		if err != nil || !changed {
+			return expanded, err
+		}
+		expr = expanded
+	}
+	return "", fmt.Errorf("cel function calls nested deeper than %d: %s", maxCELExpandDepth, expr)
+}
+
+// expandOnce 将每个 name(args...) 替换为 (body)，body中的参数替换为 (arg)
+func (that *CELLibrary) expandOnce(expr string) (string, bool, error) {
+	var err error
+	changed := false
+	ret := rewriteCELIdents(expr, func(name string, rest string) (string, int, bool) {
+		fn, ok := that.functions[name]
+		if !ok || err != nil {
+			return "", 0, false
+		}
+		trimmed := strings.TrimLeft(rest, " \t\n")
+		if !strings.HasPrefix(trimmed, "(") {
+			return "", 0, false
+		}
+		args, n, parseErr := splitCELArgs(trimmed)
+		if parseErr != nil {
+			err = fmt.Errorf("cel function %s: %w", name, parseErr)
+			return "", 0, false
+		}
+		if len(args) != len(fn.params) {
+			err = fmt.Errorf("cel function %s expects %d arguments, got %d", name, len(fn.params), len(args))
+			return "", 0, false
+		}
+		values := make(map[string]string, len(args))
+		for i, param := range fn.params {
+			values[param] = "(" + strings.TrimSpace(args[i]) + ")"
+		}
+		body := rewriteCELIdents(fn.body, func(ident string, _ string) (string, int, bool) {
+			v, ok := values[ident]
+			return v, 0, ok
+		})
+		changed = true
+		return "(" + body + ")", len(rest) - len(trimmed) + n, true
+	})
+	return ret, changed, err
+}
+
+// rewriteCELIdents 遍历表达式中的标识符(跳过字符串字面量和字段/方法访问)，
+// replace返回替换内容及额外消耗的后续字符数
+func rewriteCELIdents(expr string, replace func(name string, rest string) (string, int, bool)) string {
+	var b strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == '\'' || c == '"' {
+			j := skipCELString(expr, i)
+			b.WriteString(expr[i:j])
+			i = j
+			continue
+		}
+		if !isCELIdentStart(c) || (i > 0 && (isCELIdentChar(expr[i-1]) || expr[i-1] == '.')) {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		j := i
+		for j < len(expr) && isCELIdentChar(expr[j]) {
+			j++
+		}
+		if v, n, ok := replace(expr[i:j], expr[j:]); ok {
+			b.WriteString(v)
+			i = j + n
+			continue
+		}
+		b.WriteString(expr[i:j])
+		i = j
+	}
+	return b.String()
+}
+
+// splitCELArgs 解析以 ( 开头的参数列表，返回各参数及消耗的字符数
+func splitCELArgs(s string) ([]string, int, error) {
+	args := make([]string, 0)
+	depth, start := 0, 1
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case '\'', '"':
+			i = skipCELString(s, i)
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				if arg := strings.TrimSpace(s[start:i]); len(arg) > 0 || len(args) > 0 {
+					args = append(args, arg)
+				}
+				return args, i + 1, nil
+			}
+		case ',':
+			if depth == 1 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+		i++
+	}
+	return nil, 0, fmt.Errorf("unbalanced parentheses in %q", s)
+}
+
+// skipCELString 返回从i开始的字符串字面量之后的位置，支持三引号和转义
+func skipCELString(s string, i int) int {
+	quote := s[i : i+1]
+	if strings.HasPrefix(s[i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	for j := i + len(quote); j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if strings.HasPrefix(s[j:], quote) {
+			return j + len(quote)
+		}
+	}
+	return len(s)
+}
+
+func isCELIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isCELIdentChar(c byte) bool {
+	return isCELIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// rewrite 填充引用库中约束的id，并展开函数调用；NewValidator时已检查过已注册的消息，
+// 之后注册的消息展开失败时保留原表达式，由protovalidate报告编译错误
+func (that *CELLibrary) rewrite(constraints []*validate.Constraint) {
+	for _, c := range constraints {
+		if len(c.GetExpression()) == 0 {
+			that.mu.RLock()
+			shared, ok := that.constraints[c.GetId()]
+			that.mu.RUnlock()
+			if ok {
+				c.Expression = shared.GetExpression()
+				if len(c.GetMessage()) == 0 {
+					c.Message = shared.GetMessage()
+				}
+			}
+		}
+		expanded, err := that.Expand(c.GetExpression())
+		if err != nil {
+			logrus.Errorf("expand cel constraint %s failed: %v", c.GetId(), err)
+			continue
+		}
+		c.Expression = expanded
+	}
+}
+
+func (that *CELLibrary) rewriteField(constraints *validate.FieldConstraints) {
+	if constraints == nil {
+		return
+	}
+	that.rewrite(constraints.GetCel())
+	that.rewriteField(constraints.GetRepeated().GetItems())
+	that.rewriteField(constraints.GetMap().GetKeys())
+	that.rewriteField(constraints.GetMap().GetValues())
+}
+
+// check 展开库中的约束以及files中所有消息注解里的CEL约束，返回所有展开失败的约束
+func (that *CELLibrary) check(files *protoregistry.Files) error {
+	that.mu.RLock()
+	ids := make([]string, 0, len(that.constraints))
+	for id := range that.constraints {
+		ids = append(ids, id)
+	}
+	that.mu.RUnlock()
+	slices.Sort(ids)
+	errs := make([]error, 0)
+	for _, id := range ids {
+		that.mu.RLock()
+		expr := that.constraints[id].GetExpression()
+		that.mu.RUnlock()
+		if _, err := that.Expand(expr); err != nil {
+			errs = append(errs, fmt.Errorf("cel constraint %s: %w", id, err))
+		}
+	}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		errs = append(errs, that.checkMessages(fd.Messages())...)
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+func (that *CELLibrary) checkMessages(messages protoreflect.MessageDescriptors) []error {
+	errs := make([]error, 0)
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if proto.HasExtension(md.Options(), validate.E_Message) {
+			constraints := proto.GetExtension(md.Options(), validate.E_Message).(*validate.MessageConstraints)
+			errs = append(errs, that.checkConstraints(md.FullName(), constraints.GetCel())...)
+		}
+		fields := md.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			fd := fields.Get(j)
+			if proto.HasExtension(fd.Options(), validate.E_Field) {
+				constraints := proto.GetExtension(fd.Options(), validate.E_Field).(*validate.FieldConstraints)
+				errs = append(errs, that.checkField(fd.FullName(), constraints)...)
+			}
+		}
+		errs = append(errs, that.checkMessages(md.Messages())...)
+	}
+	return errs
+}
+
+func (that *CELLibrary) checkField(name protoreflect.FullName, constraints *validate.FieldConstraints) []error {
+	if constraints == nil {
+		return nil
+	}
+	errs := that.checkConstraints(name, constraints.GetCel())
+	errs = append(errs, that.checkField(name, constraints.GetRepeated().GetItems())...)
+	errs = append(errs, that.checkField(name, constraints.GetMap().GetKeys())...)
+	return append(errs, that.checkField(name, constraints.GetMap().GetValues())...)
+}
+
+// checkConstraints 只检查写了表达式的约束，仅引用id的约束使用库中已检查过的表达式
+func (that *CELLibrary) checkConstraints(name protoreflect.FullName, constraints []*validate.Constraint) []error {
+	errs := make([]error, 0)
+	for _, c := range constraints {
+		if len(c.GetExpression()) == 0 {
+			continue
+		}
+		if _, err := that.Expand(c.GetExpression()); err != nil {
+			errs = append(errs, fmt.Errorf("cel constraint %s of %s: %w", c.GetId(), name, err))
+		}
+	}
+	return errs
+}
+
+// celLibraryResolver 在protovalidate解析注解后改写其中的CEL约束
+type celLibraryResolver struct {
+	protovalidate.StandardConstraintResolver
+	library *CELLibrary
+}
+
+func (that celLibraryResolver) ResolveMessageConstraints(desc protoreflect.MessageDescriptor) *validate.MessageConstraints {
+	constraints := that.StandardConstraintResolver.ResolveMessageConstraints(desc)
+	if len(constraints.GetCel()) == 0 {
+		return constraints
+	}
+	constraints = proto.Clone(constraints).(*validate.MessageConstraints)
+	that.library.rewrite(constraints.GetCel())
+	return constraints
+}
+
+func (that celLibraryResolver) ResolveFieldConstraints(desc protoreflect.FieldDescriptor) *validate.FieldConstraints {
+	constraints := that.StandardConstraintResolver.ResolveFieldConstraints(desc)
+	if constraints == nil {
+		return nil
+	}
+	constraints = proto.Clone(constraints).(*validate.FieldConstraints)
+	that.library.rewriteField(constraints)
+	return constraints
+}
+
+// WithCELLibrary 在校验器中启用CEL规则库，NewValidator时检查已注册的proto注解，CEL约束展开失败时返回error
+func WithCELLibrary(library *CELLibrary) ValidatorOption {
+	return func(v *Validator) {
+		v.celLibraries = append(v.celLibraries, library)
+		WithProtovalidateOptions(protovalidate.WithStandardConstraintInterceptor(
+			func(res protovalidate.StandardConstraintResolver) protovalidate.StandardConstraintResolver {
+				return celLibraryResolver{StandardConstraintResolver: res, library: library}
+			},
+		))(v)
+	}
+}
+
+// CommonCELLibrary 常用的规则: 手机号、slug、雪花id等
+func CommonCELLibrary() *CELLibrary {
+	return NewCELLibrary().
+		Function("is_cn_mobile", []string{"s"}, `s.matches('^1[3-9][0-9]{9}$')`).
+		Function("is_e164", []string{"s"}, `s.matches('^\\+[1-9][0-9]{6,14}$')`).
+		Function("is_slug", []string{"s"}, `s.matches('^[a-z0-9]+(-[a-z0-9]+)*$') && size(s) <= 64`).
+		Function("is_snowflake_id", []string{"s"}, `s.matches('^[1-9][0-9]{9,18}$')`).
+		Constraint("cn_mobile", "value must be a valid mobile phone number", "is_cn_mobile(this)").
+		Constraint("e164", "value must be a phone number in E.164 format", "is_e164(this)").
+		Constraint("slug", "value must be a lowercase slug", "is_slug(this)")
+}
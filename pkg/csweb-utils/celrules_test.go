@@ -0,0 +1,110 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestCELLibrary_Expand(t *testing.T) {
+	lib := NewCELLibrary().
+		Function("is_tenant_id", []string{"s"}, `s.matches('^t_[a-z0-9]{8}$')`).
+		Function("is_tenant_slug", []string{"tenant", "slug"}, `is_tenant_id(tenant) && slug.startsWith(tenant + '-')`)
+
+	expr, err := lib.Expand(`is_tenant_id(this)`)
+	assert.Nil(t, err)
+	assert.Equal(t, `((this).matches('^t_[a-z0-9]{8}$'))`, expr)
+	// 嵌套调用、字符串中的同名文本和方法调用不展开
+	expr, err = lib.Expand(`is_tenant_slug(this.tenant, this.slug) || this.name == 'is_tenant_id(x)'`)
+	assert.Nil(t, err)
+	assert.Equal(t, `((((this.tenant)).matches('^t_[a-z0-9]{8}$')) && (this.slug).startsWith((this.tenant) + '-')) || this.name == 'is_tenant_id(x)'`, expr)
+	_, err = lib.Expand(`is_tenant_id(a, b)`)
+	assert.NotNil(t, err)
+	lib.Function("loop", []string{"x"}, "loop(x)")
+	_, err = lib.Expand(`loop(1)`)
+	assert.NotNil(t, err)
+}
+
+// newCELTestMessage 动态构建带 (buf.validate.field).cel 注解的消息
+func newCELTestMessage(t *testing.T, constraints ...*validate.Constraint) protoreflect.MessageDescriptor {
+	fieldOpts := &descriptorpb.FieldOptions{}
+	proto.SetExtension(fieldOpts, validate.E_Field, &validate.FieldConstraints{Cel: constraints})
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("csweb_test/cel.proto"),
+		Package: proto.String("csweb_test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Tenant"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("id"),
+				JsonName: proto.String("id"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Options:  fieldOpts,
+			}},
+		}},
+	}, nil)
+	assert.Nil(t, err)
+	return fd.Messages().Get(0)
+}
+
+func TestWithCELLibrary(t *testing.T) {
+	md := newCELTestMessage(t,
+		&validate.Constraint{Id: "tenant_id", Message: "invalid tenant id", Expression: "is_tenant_id(this)"},
+		&validate.Constraint{Id: "slug"},
+	)
+	lib := CommonCELLibrary().Function("is_tenant_id", []string{"s"}, `s.startsWith('t-')`)
+	validator, err := NewValidator(WithCELLibrary(lib))
+	assert.Nil(t, err)
+
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("id"), protoreflect.ValueOfString("t-abc"))
+	assert.Nil(t, validator.Validate(context.Background(), msg))
+
+	msg.Set(md.Fields().ByName("id"), protoreflect.ValueOfString("T_ABC"))
+	err = validator.Validate(context.Background(), msg)
+	s := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, s.Code())
+	badRequest := s.Details()[0].(*errdetails.BadRequest)
+	assert.Len(t, badRequest.GetFieldViolations(), 2)
+	assert.Equal(t, "invalid tenant id", badRequest.GetFieldViolations()[0].GetDescription())
+	assert.Equal(t, "value must be a lowercase slug", badRequest.GetFieldViolations()[1].GetDescription())
+
+	// 未启用规则库时无法编译，属于服务端配置错误
+	validator, err = NewValidator()
+	assert.Nil(t, err)
+	assert.Equal(t, codes.Internal, status.Code(validator.Validate(context.Background(), msg)))
+}
+
+func TestCELLibrary_Check(t *testing.T) {
+	lib := NewCELLibrary().Function("is_order_no", []string{"s"}, `s.startsWith('o-')`)
+	md := newCELTestMessage(t, &validate.Constraint{Id: "order_no", Expression: "is_order_no(this, 1)"})
+	files := &protoregistry.Files{}
+	assert.Nil(t, files.RegisterFile(md.ParentFile()))
+	err := lib.check(files)
+	assert.ErrorContains(t, err, "cel constraint order_no of csweb_test.Tenant.id")
+	assert.Nil(t, NewCELLibrary().check(files))
+
+	// 规则库中的约束无法展开时NewValidator返回error，避免启动后才发现
+	lib.Constraint("order_no", "invalid order no", "is_order_no()")
+	_, err = NewValidator(WithCELLibrary(lib))
+	assert.ErrorContains(t, err, "cel constraint order_no")
+	_, err = NewValidator(WithCELLibrary(CommonCELLibrary()))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,268 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConcurrencyLimit 并发数上限的计算策略，参考Netflix concurrency-limits
+type ConcurrencyLimit interface {
+	// Limit 当前允许的最大并发数
+	Limit() int
+	// OnSample 每个请求结束时调用，rtt为请求耗时，inflight为请求开始时的并发数，dropped表示请求超时或被下游拒绝
+	OnSample(rtt time.Duration, inflight int, dropped bool)
+}
+
+// FixedLimit 固定的并发数上限
+type FixedLimit struct {
+	limit int
+}
+
+func NewFixedLimit(limit int) *FixedLimit {
+	return &FixedLimit{limit: limit}
+}
+
+func (that *FixedLimit) Limit() int {
+	return that.limit
+}
+
+func (that *FixedLimit) OnSample(time.Duration, int, bool) {}
+
+// AIMDLimit 加性增、乘性减：请求被丢弃或超过timeout时按backoffRatio缩小上限，
+// 并发数接近上限且请求正常时上限加1
+type AIMDLimit struct {
+	mu           sync.Mutex
+	limit        float64
+	min, max     int
+	backoffRatio float64
+	timeout      time.Duration
+}
+
+// NewAIMDLimit initial为初始并发数上限，上限在[min, max]之间调整
+func NewAIMDLimit(initial, min, max int) *AIMDLimit {
+	return &AIMDLimit{
+		limit:        float64(initial),
+		min:          min,
+		max:          max,
+		backoffRatio: 0.9,
+		timeout:      5 * time.Second,
+	}
+}
+
+// WithBackoff 设置乘性减的比例([0.5, 1))和视为丢弃的请求耗时，比例超出范围时panic
+func (that *AIMDLimit) WithBackoff(ratio float64, timeout time.Duration) *AIMDLimit {
+	if ratio < 0.5 || ratio >= 1 {
+		panic(fmt.Sprintf("aimd backoff ratio must be in [0.5, 1), got %v", ratio))
+	}
+	that.backoffRatio = ratio
+	that.timeout = timeout
+	return that
+}
+
+func (that *AIMDLimit) Limit() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return int(that.limit)
+}
+
+func (that *AIMDLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if dropped || (that.timeout > 0 && rtt > that.timeout) {
+		that.limit = that.limit * that.backoffRatio
+	} else if inflight*2 >= int(that.limit) {
+		// 并发数未达到上限的一半时，说明上限不是瓶颈，不再增加
+		that.limit++
+	}
+	that.limit = clampLimit(that.limit, that.min, that.max)
+}
+
+// GradientLimit 根据长期平均耗时与当前耗时的比值(梯度)调整上限：耗时上升说明开始排队，按比例缩小上限，
+// 耗时平稳时允许额外sqrt(limit)的排队空间以探测更高的上限
+type GradientLimit struct {
+	mu        sync.Mutex
+	limit     float64
+	min, max  int
+	smoothing float64 // 上限变化的平滑系数
+	tolerance float64 // 允许的耗时上升比例，超过后才开始缩小上限
+	longRtt   float64 // 长期耗时的指数移动平均(纳秒)
+	longAlpha float64
+}
+
+// NewGradientLimit initial为初始并发数上限，上限在[min, max]之间调整
+func NewGradientLimit(initial, min, max int) *GradientLimit {
+	return &GradientLimit{
+		limit:     float64(initial),
+		min:       min,
+		max:       max,
+		smoothing: 0.2,
+		tolerance: 1.5,
+		longAlpha: 2.0 / (600 + 1),
+	}
+}
+
+func (that *GradientLimit) Limit() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return int(that.limit)
+}
+
+func (that *GradientLimit) OnSample(rtt time.Duration, inflight int, dropped bool) {
+	if rtt <= 0 {
+		return
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	shortRtt := float64(rtt)
+	if that.longRtt == 0 {
+		that.longRtt = shortRtt
+	} else {
+		that.longRtt = that.longRtt*(1-that.longAlpha) + shortRtt*that.longAlpha
+	}
+	// 并发数远低于上限时，耗时不能反映上限是否合适
+	if !dropped && float64(inflight) < that.limit/2 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1.0, that.tolerance*that.longRtt/shortRtt))
+	if dropped {
+		gradient = 0.5
+	}
+	newLimit := that.limit*gradient + math.Sqrt(that.limit)
+	newLimit = that.limit*(1-that.smoothing) + newLimit*that.smoothing
+	that.limit = clampLimit(newLimit, that.min, that.max)
+}
+
+// clampLimit 上限至少为1，否则所有请求都被拒绝，不再有样本能让上限恢复
+func clampLimit(limit float64, min, max int) float64 {
+	if min < 1 {
+		min = 1
+	}
+	if limit < float64(min) {
+		return float64(min)
+	}
+	if max > 0 && limit > float64(max) {
+		return float64(max)
+	}
+	return limit
+}
+
+// ConcurrencyLimiter 限制同时处理的请求数，超过上限的请求直接拒绝
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    ConcurrencyLimit
+	inflight int
+	clock    Clock
+}
+
+func NewConcurrencyLimiter(limit ConcurrencyLimit, clock Clock) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limit: limit, clock: clock}
+}
+
+// Acquire 获取一个并发名额，失败返回false，成功时请求结束后必须调用release
+func (that *ConcurrencyLimiter) Acquire() (release func(dropped bool), ok bool) {
+	return that.AcquireShare(1)
+}
+
+// AcquireShare 仅当并发数低于上限的share比例时获取名额，用于为高优先级的请求保留余量
+func (that *ConcurrencyLimiter) AcquireShare(share float64) (release func(dropped bool), ok bool) {
+	that.mu.Lock()
+	limit := int(math.Ceil(float64(that.limit.Limit()) * share))
+	if that.inflight >= limit {
+		that.mu.Unlock()
+		return nil, false
+	}
+	that.inflight++
+	inflight := that.inflight
+	that.mu.Unlock()
+	start := that.clock.Now()
+	var once sync.Once
+	return func(dropped bool) {
+		once.Do(func() {
+			that.limit.OnSample(that.clock.Now().Sub(start), inflight, dropped)
+			that.mu.Lock()
+			that.inflight--
+			that.mu.Unlock()
+		})
+	}, true
+}
+
+// Inflight 当前正在处理的请求数
+func (that *ConcurrencyLimiter) Inflight() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.inflight
+}
+
+// Limit 当前的并发数上限
+func (that *ConcurrencyLimiter) Limit() int {
+	return that.limit.Limit()
+}
+
+var (
+	ConcurrencyLimitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_server_concurrency_limit",
+		Help: "Current concurrency limit of gRPC methods.",
+	}, []string{"grpc_method"})
+	ConcurrencyInflightGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "grpc_server_concurrency_inflight",
+		Help: "Number of gRPC requests currently in flight.",
+	}, []string{"grpc_method"})
+	ConcurrencyShedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_concurrency_shed_total",
+		Help: "Total number of gRPC requests shed by the concurrency limiter.",
+	}, []string{"grpc_method"})
+)
+
+// WithConcurrencyLimit return a new unary server interceptor that caps in-flight requests per method,
+// newLimit 为每个方法创建独立的上限策略(FixedLimit/AIMDLimit/GradientLimit)，
+// 启用WithPriority时低优先级的请求只能使用部分并发名额
+func WithConcurrencyLimit(newLimit func() ConcurrencyLimit) grpc.UnaryServerInterceptor {
+	var mu sync.Mutex
+	limiters := make(map[string]*ConcurrencyLimiter)
+	getLimiter := func(method string) *ConcurrencyLimiter {
+		mu.Lock()
+		defer mu.Unlock()
+		limiter, ok := limiters[method]
+		if !ok {
+			limiter = NewConcurrencyLimiter(newLimit(), SystemClock)
+			limiters[method] = limiter
+		}
+		return limiter
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		limiter := getLimiter(info.FullMethod)
+		release, ok := limiter.AcquireShare(admissionShare(ctx))
+		if !ok {
+			ConcurrencyShedCounter.WithLabelValues(info.FullMethod).Inc()
+			recordShed(ctx, "concurrency")
+			return nil, status.Error(codes.ResourceExhausted, "too many concurrent requests, please retry later")
+		}
+		ConcurrencyInflightGauge.WithLabelValues(info.FullMethod).Set(float64(limiter.Inflight()))
+		// handler panic时也要释放名额，panic由WithRecovery转换为错误
+		dropped := false
+		defer func() {
+			release(dropped)
+			ConcurrencyInflightGauge.WithLabelValues(info.FullMethod).Set(float64(limiter.Inflight()))
+			ConcurrencyLimitGauge.WithLabelValues(info.FullMethod).Set(float64(limiter.Limit()))
+		}()
+		resp, err = handler(ctx, req)
+		// 超时或下游过载说明已超出处理能力
+		switch status.Code(err) {
+		case codes.DeadlineExceeded, codes.ResourceExhausted, codes.Unavailable:
+			dropped = true
+		}
+		return resp, err
+	}
+}
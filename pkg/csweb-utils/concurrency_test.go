@@ -0,0 +1,118 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConcurrencyLimiter_Fixed(t *testing.T) {
+	limiter := NewConcurrencyLimiter(NewFixedLimit(2), newFakeClock())
+	release1, ok := limiter.Acquire()
+	assert.True(t, ok)
+	_, ok = limiter.Acquire()
+	assert.True(t, ok)
+	_, ok = limiter.Acquire()
+	assert.False(t, ok)
+	release1(false)
+	release1(false)
+	assert.Equal(t, 1, limiter.Inflight())
+	_, ok = limiter.Acquire()
+	assert.True(t, ok)
+}
+
+func TestAIMDLimit(t *testing.T) {
+	limit := NewAIMDLimit(10, 5, 12)
+	limit.OnSample(time.Millisecond, 2, false)
+	assert.Equal(t, 10, limit.Limit())
+	limit.OnSample(time.Millisecond, 6, false)
+	assert.Equal(t, 11, limit.Limit())
+	limit.OnSample(time.Millisecond, 6, false)
+	limit.OnSample(time.Millisecond, 6, false)
+	assert.Equal(t, 12, limit.Limit())
+	limit.OnSample(time.Millisecond, 6, true)
+	assert.Equal(t, 10, limit.Limit())
+	limit.OnSample(10*time.Second, 6, false)
+	assert.Equal(t, 9, limit.Limit())
+	for i := 0; i < 20; i++ {
+		limit.OnSample(time.Millisecond, 6, true)
+	}
+	assert.Equal(t, 5, limit.Limit())
+}
+
+func TestGradientLimit(t *testing.T) {
+	limit := NewGradientLimit(20, 5, 100)
+	// 耗时平稳时逐步增加上限
+	for i := 0; i < 10; i++ {
+		limit.OnSample(10*time.Millisecond, 20, false)
+	}
+	grown := limit.Limit()
+	assert.Greater(t, grown, 20)
+	// 耗时明显上升后缩小上限
+	for i := 0; i < 10; i++ {
+		limit.OnSample(100*time.Millisecond, grown, false)
+	}
+	assert.Less(t, limit.Limit(), grown)
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	interceptor := WithConcurrencyLimit(func() ConcurrencyLimit { return NewFixedLimit(1) })
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Create"}
+	entered, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			close(entered)
+			<-done
+			return nil, nil
+		})
+	}()
+	<-entered
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	_, err := interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// 其他方法不受影响
+	resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/order.Order/Get"}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", resp)
+	close(done)
+}
+
+func TestAIMDLimit_Floor(t *testing.T) {
+	// min为0时上限也不会降到1以下，否则所有请求被拒绝后上限无法恢复
+	limiter := NewConcurrencyLimiter(NewAIMDLimit(4, 0, 10).WithBackoff(0.5, time.Second), newFakeClock())
+	for i := 0; i < 20; i++ {
+		release, ok := limiter.Acquire()
+		assert.True(t, ok)
+		release(true)
+	}
+	assert.Equal(t, 1, limiter.Limit())
+	release, ok := limiter.Acquire()
+	assert.True(t, ok)
+	release(false)
+
+	assert.Panics(t, func() { NewAIMDLimit(4, 0, 10).WithBackoff(0.2, time.Second) })
+	assert.Panics(t, func() { NewAIMDLimit(4, 0, 10).WithBackoff(1, time.Second) })
+}
+
+func TestWithConcurrencyLimit_Panic(t *testing.T) {
+	interceptor := WithConcurrencyLimit(func() ConcurrencyLimit { return NewFixedLimit(1) })
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Create"}
+	assert.Panics(t, func() {
+		_, _ = interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+			panic("boom")
+		})
+	})
+	// panic的请求已释放名额
+	resp, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) { return "ok", nil })
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", resp)
+}
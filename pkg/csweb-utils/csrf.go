@@ -0,0 +1,135 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+// CSRFConfig 基于cookie认证的网关请求的CSRF防护配置
+type CSRFConfig struct {
+	Key            []byte   // 签名密钥，不能为空
+	AuthCookie     string   // 认证cookie，默认 token，与CookieToAuth一致
+	CookieName     string   // csrf token cookie，默认 csrf_token
+	HeaderName     string   // csrf token header，默认 X-CSRF-Token
+	TokenPath      string   // 获取csrf token的接口，默认 /auth/csrf
+	ExemptPaths    []string // 免校验的路径，支持path.Match通配符
+	InsecureCookie bool     // 不设置Secure，仅用于本地http调试
+}
+
+// WithCSRF 对使用cookie认证(未携带Authorization/x-api-key)的非安全方法请求做double-submit校验：
+// header中的csrf token必须与cookie一致，且token与当前认证cookie绑定签名，防止子域注入cookie
+func WithCSRF(h http.Handler, conf CSRFConfig) http.Handler {
+	if len(conf.AuthCookie) == 0 {
+		conf.AuthCookie = "token"
+	}
+	if len(conf.CookieName) == 0 {
+		conf.CookieName = "csrf_token"
+	}
+	if len(conf.HeaderName) == 0 {
+		conf.HeaderName = "X-CSRF-Token"
+	}
+	if len(conf.TokenPath) == 0 {
+		conf.TokenPath = "/auth/csrf"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := ""
+		if c, err := r.Cookie(conf.AuthCookie); err == nil {
+			session = c.Value
+		}
+		// 获取csrf token
+		if r.Method == http.MethodGet && r.URL.Path == conf.TokenPath {
+			token := conf.newToken(session)
+			http.SetCookie(w, &http.Cookie{
+				Name:     conf.CookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   !conf.InsecureCookie,
+				SameSite: http.SameSiteLaxMode,
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(map[string]string{"csrfToken": token})
+			return
+		}
+		if !conf.required(r, session) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(conf.CookieName)
+		header := r.Header.Get(conf.HeaderName)
+		if err != nil || len(header) == 0 || !hmac.Equal([]byte(header), []byte(cookie.Value)) || !conf.verifyToken(header, session) {
+			writeErrResp(w, r, codes.PermissionDenied, "invalid csrf token")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// required 是否需要校验csrf token，仅针对通过cookie认证的非安全方法请求
+func (conf CSRFConfig) required(r *http.Request, session string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	if len(session) == 0 || len(r.Header.Get("Authorization")) > 0 || len(r.Header.Get(APIKeyHeader)) > 0 {
+		return false
+	}
+	for _, pattern := range conf.ExemptPaths {
+		if ok, _ := path.Match(pattern, r.URL.Path); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// newToken 生成与认证cookie绑定的csrf token: nonce.HMAC(nonce|session)
+func (conf CSRFConfig) newToken(session string) string {
+	nonce := randomString()
+	return nonce + "." + conf.sign(nonce, session)
+}
+
+func (conf CSRFConfig) verifyToken(token, session string) bool {
+	nonce, sig, ok := strings.Cut(token, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(conf.sign(nonce, session)))
+}
+
+func (conf CSRFConfig) sign(nonce, session string) string {
+	mac := hmac.New(sha256.New, conf.Key)
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'|'})
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// writeErrResp 在grpc-gateway之外按ErrResp格式写入错误响应
+func writeErrResp(w http.ResponseWriter, r *http.Request, code codes.Code, msg string) {
+	buf, err := json.Marshal(ErrResp{
+		Status: Status{
+			TraceId: GetTraceId(r.Context()),
+			Code:    int32(code),
+			Message: msg,
+		},
+	})
+	if err != nil {
+		logrus.Infof("Failed to marshal response: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(code))
+	if _, err := w.Write(buf); err != nil {
+		logrus.Infof("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithCSRF(t *testing.T) {
+	h := WithCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), CSRFConfig{Key: []byte("hello"), ExemptPaths: []string{"/webhook/*"}})
+	session := &http.Cookie{Name: "token", Value: "session-1"}
+	do := func(method, path string, cookies []*http.Cookie, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// 获取csrf token
+	w := do(http.MethodGet, "/auth/csrf", []*http.Cookie{session}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := map[string]string{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	token := body["csrfToken"]
+	csrfCookie := &http.Cookie{Name: "csrf_token", Value: token}
+
+	// cookie认证的POST必须携带csrf token
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/v1/orders", []*http.Cookie{session}, nil).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/orders", []*http.Cookie{session, csrfCookie}, map[string]string{"X-CSRF-Token": token}).Code)
+	// header与cookie不一致
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/v1/orders", []*http.Cookie{session, csrfCookie}, map[string]string{"X-CSRF-Token": "x"}).Code)
+	// token与其他会话绑定
+	other := &http.Cookie{Name: "token", Value: "session-2"}
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/v1/orders", []*http.Cookie{other, csrfCookie}, map[string]string{"X-CSRF-Token": token}).Code)
+
+	// 安全方法、显式Authorization、免校验路径、未登录不校验
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/v1/orders", []*http.Cookie{session}, nil).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/orders", []*http.Cookie{session}, map[string]string{"Authorization": "bearer x"}).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/webhook/pay", []*http.Cookie{session}, nil).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/v1/orders", nil, nil).Code)
+}
@@ -8,23 +8,63 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type Status struct {
-	TraceId string `json:"traceId"`
-	Code    int32  `json:"code"`
+	TraceId          string `json:"traceId"`
+	Code             int32  `json:"code"`
+	Message          string `json:"message"`
+	LocalizedMessage string `json:"localizedMessage,omitempty"` // 按Accept-Language翻译的面向用户的消息
+}
+
+// FieldError 请求参数中单个字段的错误
+type FieldError struct {
+	Field   string `json:"field"`
 	Message string `json:"message"`
 }
 
 type ErrResp struct {
-	Status Status `json:"status"`
+	Status      Status       `json:"status"`
+	FieldErrors []FieldError `json:"fieldErrors,omitempty"`
+}
+
+// localizedMessage 从status的LocalizedMessage详情中提取翻译后的消息
+func localizedMessage(s *status.Status) string {
+	for _, detail := range s.Details() {
+		if msg, ok := detail.(*errdetails.LocalizedMessage); ok {
+			return msg.GetMessage()
+		}
+	}
+	return ""
+}
+
+// fieldErrors 从status的BadRequest详情中提取字段错误
+func fieldErrors(s *status.Status) []FieldError {
+	var errs []FieldError
+	for _, detail := range s.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		for _, violation := range badRequest.GetFieldViolations() {
+			errs = append(errs, FieldError{
+				Field:   violation.GetField(),
+				Message: violation.GetDescription(),
+			})
+		}
+	}
+	return errs
 }
 
 // CustomErrorHandler custom Error Handler for HTTP Server
@@ -36,16 +76,17 @@ func CustomErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler ru
 	if s.Code() == codes.Unauthenticated {
 		writer.Header().Set("WWW-Authenticate", s.Message())
 	}
-	traceId := ""
-	if span := trace.SpanContextFromContext(request.Context()); span.IsSampled() {
-		traceId = span.TraceID().String()
-	}
+	traceId := GetTraceId(ctx)
+	// 记录grpc错误，状态码由WithTrace记录
+	trace.SpanFromContext(ctx).RecordError(err)
 	resp := ErrResp{
 		Status: Status{
-			TraceId: traceId,
-			Code:    pb.GetCode(),
-			Message: pb.GetMessage(),
+			TraceId:          traceId,
+			Code:             pb.GetCode(),
+			Message:          pb.GetMessage(),
+			LocalizedMessage: localizedMessage(s),
 		},
+		FieldErrors: fieldErrors(s),
 	}
 	buf, err := marshaler.Marshal(resp)
 	if err != nil {
@@ -58,13 +99,25 @@ func CustomErrorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler ru
 	// 获取原始请求头信息
 	md, ok := runtime.ServerMetadataFromContext(ctx)
 	if ok {
-		// 透传请求头信息，修改header的key = Grpc-Metadata-<header-key>
+		// 透传请求头信息，修改header的key = Grpc-Metadata-<header-key>，限流相关的header保持原样
 		for k, vs := range md.HeaderMD {
 			for _, v := range vs {
+				if strings.HasPrefix(k, "x-ratelimit-") {
+					writer.Header().Add(k, v)
+					continue
+				}
 				writer.Header().Add(fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, k), v)
 			}
 		}
 	}
+	// 限流时根据RetryInfo设置Retry-After
+	if s.Code() == codes.ResourceExhausted {
+		for _, detail := range s.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(info.GetRetryDelay().AsDuration().Seconds()))))
+			}
+		}
+	}
 	// 写入http状态码，将code转换为http code
 	st := runtime.HTTPStatusFromCode(s.Code())
 	writer.WriteHeader(st)
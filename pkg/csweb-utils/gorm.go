@@ -6,11 +6,16 @@ package csweb_utils
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -18,11 +23,12 @@ import (
 )
 
 const GormTrace = "gorm.trace"
+const gormTraceParent = "gorm.trace.parent"
 const BeforeName = "gorm.before"
 const AfterName = "gorm.after"
 
-// NewGormDB 初始化DB
-func NewGormDB(dsn string) (*gorm.DB, error) {
+// NewGormDB 初始化DB，opts用于配置链路追踪，如 GormTraceSQL(GormSQLNone)
+func NewGormDB(dsn string, opts ...GormTracerOption) (*gorm.DB, error) {
 	newLogger := logger.Default
 	newLogger.LogMode(logger.Info)
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
@@ -38,110 +44,171 @@ func NewGormDB(dsn string) (*gorm.DB, error) {
 	sqlDB.SetMaxIdleConns(100)
 	sqlDB.SetMaxOpenConns(5000)
 	sqlDB.SetConnMaxLifetime(time.Hour)
-	gormTrace := NewGormTracer(db, "gorm.sql")
+	dbName, poolName := "default", "default"
+	if conf, err := mysqldriver.ParseDSN(dsn); err == nil && len(conf.DBName) > 0 {
+		dbName, poolName = conf.DBName, conf.Addr+"/"+conf.DBName
+	}
+	gormTrace := NewGormTracer(db, "gorm.sql", append([]GormTracerOption{GormTraceDBName(dbName)}, opts...)...)
 	if err := gormTrace.Init(); err != nil {
 		return nil, err
 	}
+	if err := NewGormMetrics(db).Init(); err != nil {
+		return nil, err
+	}
+	// 连接池指标以 地址/数据库名 区分，同一数据库的多个连接池只统计第一个
+	if err := GormDBStatsCollector.Add(db, poolName); err != nil {
+		logrus.Warn(err)
+	}
 	return db, nil
 }
 
-// GormTracer gorm trace追踪
+// CloseGormDB 关闭DB的连接池，并移除其连接池指标
+func CloseGormDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	GormDBStatsCollector.Remove(db)
+	return sqlDB.Close()
+}
+
+// GormSQLMode span中记录sql语句的方式
+type GormSQLMode int
+
+const (
+	GormSQLParameterized GormSQLMode = iota // 只记录带占位符的sql，不包含参数，默认
+	GormSQLExplain                          // 参数替换后的完整sql，可能包含用户数据，仅用于调试
+	GormSQLNone                             // 不记录sql
+)
+
+// gormOperations 各回调对应的sql操作，raw/row从sql语句中解析
+var gormOperations = map[string]string{
+	"query":  "SELECT",
+	"create": "INSERT",
+	"update": "UPDATE",
+	"delete": "DELETE",
+}
+
+// GormTracer gorm trace追踪，span以 "操作 表名" 命名，如 SELECT users
 type GormTracer struct {
 	db       *gorm.DB
-	spanName string
+	spanName string // 无法确定操作和表名时的span名称
+	dbName   string
+	sqlMode  GormSQLMode
 }
 
-func NewGormTracer(db *gorm.DB, spanName string) *GormTracer {
-	return &GormTracer{
-		db:       db,
-		spanName: spanName,
+type GormTracerOption func(g *GormTracer)
+
+// GormTraceDBName 设置db.name属性
+func GormTraceDBName(name string) GormTracerOption {
+	return func(g *GormTracer) {
+		g.dbName = name
 	}
 }
 
-func (g *GormTracer) Init() error {
-	err := g.db.Callback().Query().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Query().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
+// GormTraceSQL 设置sql语句的记录方式，默认GormSQLParameterized，避免span中记录用户数据
+func GormTraceSQL(mode GormSQLMode) GormTracerOption {
+	return func(g *GormTracer) {
+		g.sqlMode = mode
 	}
-	err = g.db.Callback().Create().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Create().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Update().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Update().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Delete().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Delete().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Raw().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
-	}
-	err = g.db.Callback().Raw().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
+}
+
+func NewGormTracer(db *gorm.DB, spanName string, opts ...GormTracerOption) *GormTracer {
+	g := &GormTracer{
+		db:       db,
+		spanName: spanName,
 	}
-	err = g.db.Callback().Row().Before("*").Register(BeforeName, g.before)
-	if err != nil {
-		return err
+	for _, opt := range opts {
+		opt(g)
 	}
-	err = g.db.Callback().Row().After("*").Register(AfterName, g.after)
-	if err != nil {
-		return err
+	return g
+}
+
+func (g *GormTracer) Init() error {
+	for _, cb := range gormCallbacks(g.db) {
+		if err := cb.before(BeforeName, g.before); err != nil {
+			return err
+		}
+		if err := cb.after(AfterName, g.after(cb.operation)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// before 以Statement.Context(db.WithContext)中的span为父span，执行期间Statement.Context替换为新span的context
 func (g *GormTracer) before(scope *gorm.DB) {
-	ctx, ok := scope.Get("ctx")
-	if !ok {
-		return
+	parentCtx := scope.Statement.Context
+	if parentCtx == nil {
+		parentCtx = context.Background()
 	}
-	parentCtx, ok := ctx.(context.Context)
-	if !ok {
-		return
+	// 兼容通过db.Set("ctx", ctx)传递的context
+	if !trace.SpanContextFromContext(parentCtx).IsValid() {
+		if ctx, ok := scope.Get("ctx"); ok {
+			if ctx, ok := ctx.(context.Context); ok {
+				parentCtx = ctx
+			}
+		}
+	}
+	attrs := []attribute.KeyValue{semconv.DBSystemKey.String(scope.Dialector.Name())}
+	if len(g.dbName) > 0 {
+		attrs = append(attrs, semconv.DBName(g.dbName))
 	}
 	tracer := otel.GetTracerProvider().Tracer("gorm")
-	_, span := tracer.Start(
-		trace.ContextWithSpanContext(parentCtx, trace.SpanContextFromContext(parentCtx)),
-		g.spanName,
-		trace.WithSpanKind(trace.SpanKindInternal),
+	ctx, span := tracer.Start(parentCtx, g.spanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
 	)
+	scope.InstanceSet(gormTraceParent, scope.Statement.Context)
+	scope.Statement.Context = ctx
 	scope.InstanceSet(GormTrace, span)
 }
 
-func (g *GormTracer) after(scope *gorm.DB) {
-	ret, ok := scope.InstanceGet(GormTrace)
-	if !ok {
-		return
-	}
-	span, ok := ret.(trace.Span)
-	if !ok {
-		return
-	}
-	defer span.End()
-	// 输出sql语句
-	span.SetAttributes(attribute.String("sql", scope.Dialector.Explain(scope.Statement.SQL.String(), scope.Statement.Vars...)))
-	if scope.Error != nil && scope.Error != gorm.ErrRecordNotFound {
-		span.SetStatus(codes.Error, scope.Error.Error())
+func (g *GormTracer) after(operation string) func(scope *gorm.DB) {
+	return func(scope *gorm.DB) {
+		ret, ok := scope.InstanceGet(GormTrace)
+		if !ok {
+			return
+		}
+		span, ok := ret.(trace.Span)
+		if !ok {
+			return
+		}
+		defer span.End()
+		// 链式调用时Statement会被复用，恢复原来的context
+		if parent, ok := scope.InstanceGet(gormTraceParent); ok {
+			scope.Statement.Context, _ = parent.(context.Context)
+		}
+		sql := scope.Statement.SQL.String()
+		op, ok := gormOperations[operation]
+		if !ok {
+			op, _, _ = strings.Cut(strings.TrimSpace(sql), " ")
+			op = strings.ToUpper(op)
+		}
+		table := scope.Statement.Table
+		switch {
+		case len(op) > 0 && len(table) > 0:
+			span.SetName(op + " " + table)
+		case len(op) > 0:
+			span.SetName(op)
+		}
+		if len(op) > 0 {
+			span.SetAttributes(semconv.DBOperation(op))
+		}
+		if len(table) > 0 {
+			span.SetAttributes(semconv.DBSQLTable(table))
+		}
+		switch g.sqlMode {
+		case GormSQLExplain:
+			span.SetAttributes(semconv.DBStatement(scope.Dialector.Explain(sql, scope.Statement.Vars...)))
+		case GormSQLParameterized:
+			span.SetAttributes(semconv.DBStatement(sql))
+		}
+		span.SetAttributes(attribute.Int64("db.rows_affected", scope.RowsAffected))
+		if scope.Error != nil && !errors.Is(scope.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(scope.Error)
+			span.SetStatus(codes.Error, scope.Error.Error())
+		}
 	}
 }
 
@@ -179,14 +246,13 @@ func NewTxContext(ctx context.Context, db *gorm.DB) context.Context {
 	return context.WithValue(ctx, txKey{}, db)
 }
 
-// CurrentDB 如果context里面有事务连接则使用事务连接，否则使用db
+// CurrentDB 如果context里面有事务连接则使用事务连接，否则使用db，并传递ctx用于链路追踪
 func CurrentDB(ctx context.Context, db *gorm.DB) *gorm.DB {
-	db = db.Set("ctx", ctx) // 传递span ctx
 	tx := TxFromContext(ctx)
 	if tx != nil {
-		return tx
+		return tx.WithContext(ctx)
 	} else {
-		return db
+		return db.WithContext(ctx)
 	}
 }
 
@@ -0,0 +1,205 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+const gormMetricsStart = "gorm.metrics.start"
+
+var (
+	GormQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gorm_query_duration_seconds",
+		Help:    "Latency of GORM statements by operation and table.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"operation", "table"})
+	GormErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_errors_total",
+		Help: "Total number of failed GORM statements, record not found is not counted.",
+	}, []string{"operation", "table"})
+	GormRowsAffectedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gorm_rows_affected_total",
+		Help: "Total number of rows returned or affected by GORM statements.",
+	}, []string{"operation", "table"})
+	// GormDBStatsCollector 各个DB连接池的sql.DBStats，以db_name区分，NewGormDB创建的DB为 地址/数据库名
+	GormDBStatsCollector = newDBStatsCollector()
+)
+
+// dbStatsCollector 汇总多个DB的连接池指标，与collectors.NewDBStatsCollector相同的指标名，
+// db_name为可变标签，因此DB可以在注册到registry之后再添加
+type dbStatsCollector struct {
+	mu  sync.RWMutex
+	dbs map[string]*sql.DB
+
+	maxOpenConnections *prometheus.Desc
+	openConnections    *prometheus.Desc
+	inUseConnections   *prometheus.Desc
+	idleConnections    *prometheus.Desc
+	waitCount          *prometheus.Desc
+	waitDuration       *prometheus.Desc
+	maxIdleClosed      *prometheus.Desc
+	maxIdleTimeClosed  *prometheus.Desc
+	maxLifetimeClosed  *prometheus.Desc
+}
+
+func newDBStatsCollector() *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("go_sql_"+name, help, []string{"db_name"}, nil)
+	}
+	return &dbStatsCollector{
+		dbs:                make(map[string]*sql.DB),
+		maxOpenConnections: desc("max_open_connections", "Maximum number of open connections to the database."),
+		openConnections:    desc("open_connections", "The number of established connections both in use and idle."),
+		inUseConnections:   desc("in_use_connections", "The number of connections currently in use."),
+		idleConnections:    desc("idle_connections", "The number of idle connections."),
+		waitCount:          desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration:       desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+		maxIdleClosed:      desc("max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns."),
+		maxIdleTimeClosed:  desc("max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime."),
+		maxLifetimeClosed:  desc("max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime."),
+	}
+}
+
+// Add 添加DB连接池指标，dbName重复时返回错误，DB关闭时需调用Remove
+func (that *dbStatsCollector) Add(db *gorm.DB, dbName string) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if _, ok := that.dbs[dbName]; ok {
+		return fmt.Errorf("db stats of %s already collected", dbName)
+	}
+	that.dbs[dbName] = sqlDB
+	return nil
+}
+
+// Remove 移除DB的连接池指标，DB关闭后不再上报
+func (that *dbStatsCollector) Remove(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return
+	}
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	for name, v := range that.dbs {
+		if v == sqlDB {
+			delete(that.dbs, name)
+		}
+	}
+}
+
+func (that *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- that.maxOpenConnections
+	ch <- that.openConnections
+	ch <- that.inUseConnections
+	ch <- that.idleConnections
+	ch <- that.waitCount
+	ch <- that.waitDuration
+	ch <- that.maxIdleClosed
+	ch <- that.maxIdleTimeClosed
+	ch <- that.maxLifetimeClosed
+}
+
+func (that *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	for name, db := range that.dbs {
+		stats := db.Stats()
+		ch <- prometheus.MustNewConstMetric(that.maxOpenConnections, prometheus.GaugeValue, float64(stats.MaxOpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(that.openConnections, prometheus.GaugeValue, float64(stats.OpenConnections), name)
+		ch <- prometheus.MustNewConstMetric(that.inUseConnections, prometheus.GaugeValue, float64(stats.InUse), name)
+		ch <- prometheus.MustNewConstMetric(that.idleConnections, prometheus.GaugeValue, float64(stats.Idle), name)
+		ch <- prometheus.MustNewConstMetric(that.waitCount, prometheus.CounterValue, float64(stats.WaitCount), name)
+		ch <- prometheus.MustNewConstMetric(that.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(that.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed), name)
+		ch <- prometheus.MustNewConstMetric(that.maxIdleTimeClosed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed), name)
+		ch <- prometheus.MustNewConstMetric(that.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), name)
+	}
+}
+
+// GormMetrics 记录gorm语句的耗时、错误数和影响行数
+type GormMetrics struct {
+	db *gorm.DB
+}
+
+func NewGormMetrics(db *gorm.DB) *GormMetrics {
+	return &GormMetrics{db: db}
+}
+
+// gormCallback 一类语句的before/after回调注册函数
+type gormCallback struct {
+	operation     string
+	before, after func(name string, fn func(*gorm.DB)) error
+}
+
+// gormCallbacks 各类语句的before/after回调注册函数
+func gormCallbacks(db *gorm.DB) []gormCallback {
+	callback := db.Callback()
+	return []gormCallback{
+		{"query", callback.Query().Before("*").Register, callback.Query().After("*").Register},
+		{"create", callback.Create().Before("*").Register, callback.Create().After("*").Register},
+		{"update", callback.Update().Before("*").Register, callback.Update().After("*").Register},
+		{"delete", callback.Delete().Before("*").Register, callback.Delete().After("*").Register},
+		{"raw", callback.Raw().Before("*").Register, callback.Raw().After("*").Register},
+		{"row", callback.Row().Before("*").Register, callback.Row().After("*").Register},
+	}
+}
+
+// Init 在query/create/update/delete/raw/row的回调中记录指标
+func (that *GormMetrics) Init() error {
+	for _, cb := range gormCallbacks(that.db) {
+		if err := cb.before("gorm.metrics.before", that.before); err != nil {
+			return err
+		}
+		if err := cb.after("gorm.metrics.after", that.after(cb.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (that *GormMetrics) before(scope *gorm.DB) {
+	scope.InstanceSet(gormMetricsStart, time.Now())
+}
+
+func (that *GormMetrics) after(operation string) func(scope *gorm.DB) {
+	return func(scope *gorm.DB) {
+		ret, ok := scope.InstanceGet(gormMetricsStart)
+		if !ok {
+			return
+		}
+		start, ok := ret.(time.Time)
+		if !ok {
+			return
+		}
+		table := scope.Statement.Table
+		GormQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		if scope.Error != nil && !errors.Is(scope.Error, gorm.ErrRecordNotFound) {
+			GormErrorCounter.WithLabelValues(operation, table).Inc()
+		}
+		if scope.RowsAffected > 0 {
+			GormRowsAffectedCounter.WithLabelValues(operation, table).Add(float64(scope.RowsAffected))
+		}
+	}
+}
+
+// InitGormMetrics 为db启用语句指标和连接池指标，dbName作为连接池指标的db_name标签，
+// 同一进程中的多个DB需使用不同的dbName，关闭DB前调用 GormDBStatsCollector.Remove
+func InitGormMetrics(db *gorm.DB, dbName string) error {
+	if err := NewGormMetrics(db).Init(); err != nil {
+		return err
+	}
+	return GormDBStatsCollector.Add(db, dbName)
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type gormMetricsUser struct {
+	ID   int64
+	Name string
+}
+
+func TestGormMetrics(t *testing.T) {
+	// DryRun只生成sql不连接数据库，回调仍会执行
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:secret@tcp(127.0.0.1:3306)/csweb",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.Nil(t, err)
+	assert.Nil(t, NewGormMetrics(db).Init())
+
+	sampleCount := func(operation string) uint64 {
+		m := &dto.Metric{}
+		assert.Nil(t, GormQueryDuration.WithLabelValues(operation, "gorm_metrics_users").(prometheus.Metric).Write(m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	queries, creates := sampleCount("query"), sampleCount("create")
+	users := make([]gormMetricsUser, 0)
+	db.Find(&users)
+	db.Create(&gormMetricsUser{Name: "a"})
+	assert.Equal(t, queries+1, sampleCount("query"))
+	assert.Equal(t, creates+1, sampleCount("create"))
+
+	stats := newDBStatsCollector()
+	assert.Nil(t, stats.Add(db, "csweb"))
+	assert.NotNil(t, stats.Add(db, "csweb"))
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterCollectors(registry, stats))
+	assert.Nil(t, RegisterCollectors(registry, stats))
+	count, err := testutil.GatherAndCount(registry, "go_sql_open_connections")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// 关闭的DB不再上报
+	sqlite := newSqliteDB(t)
+	assert.Nil(t, stats.Add(sqlite, "sqlite"))
+	count, err = testutil.GatherAndCount(registry, "go_sql_open_connections")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	stats.Remove(sqlite)
+	count, err = testutil.GatherAndCount(registry, "go_sql_open_connections")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace/noop"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func TestGormTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:secret@tcp(127.0.0.1:3306)/csweb",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.Nil(t, err)
+	assert.Nil(t, NewGormTracer(db, "gorm.sql", GormTraceDBName("csweb")).Init())
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	users := make([]gormMetricsUser, 0)
+	CurrentDB(ctx, db).Where("name = ?", "bob").Find(&users)
+	db.WithContext(ctx).Exec("UPDATE gorm_metrics_users SET name = ?", "alice")
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	query, raw := spans[0], spans[1]
+	assert.Equal(t, "SELECT gorm_metrics_users", query.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Contains(t, query.Attributes(), semconv.DBSystemMySQL)
+	assert.Contains(t, query.Attributes(), semconv.DBName("csweb"))
+	assert.Contains(t, query.Attributes(), semconv.DBSQLTable("gorm_metrics_users"))
+	// 默认只记录带占位符的sql
+	assert.Contains(t, query.Attributes(), semconv.DBStatement("SELECT * FROM `gorm_metrics_users` WHERE name = ?"))
+
+	assert.Equal(t, "UPDATE", raw.Name())
+	assert.Contains(t, raw.Attributes(), semconv.DBOperation("UPDATE"))
+	assert.Equal(t, parent.SpanContext().SpanID(), raw.Parent().SpanID())
+
+	// GormSQLExplain记录参数替换后的sql
+	db, err = gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root:secret@tcp(127.0.0.1:3306)/csweb",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.Nil(t, err)
+	assert.Nil(t, NewGormTracer(db, "gorm.sql", GormTraceSQL(GormSQLExplain)).Init())
+	db.WithContext(context.Background()).Where("name = ?", "bob").Find(&users)
+	spans = recorder.Ended()
+	assert.Contains(t, spans[len(spans)-1].Attributes(), semconv.DBStatement("SELECT * FROM `gorm_metrics_users` WHERE name = 'bob'"))
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/metadata"
+)
+
+// HTTPRouteOther 没有路由模板的请求(未匹配路由返回404、直接通过mux.HandlePath注册的路由)统一使用的route标签，避免按原始路径打标签
+const HTTPRouteOther = "other"
+
+var (
+	HTTPRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_http_requests_total",
+		Help: "Total number of HTTP requests handled by the gateway.",
+	}, []string{"method", "route", "code"})
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests handled by the gateway.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+	HTTPResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_http_response_size_bytes",
+		Help:    "Size of HTTP response bodies written by the gateway.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"method", "route", "code"})
+	HTTPInflightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_http_requests_in_flight",
+		Help: "Number of HTTP requests currently handled by the gateway.",
+	})
+)
+
+// httpMethods 其他请求方法统一记为OTHER
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// httpRouteKey 在请求context中保存路由模板，由MetricsRoute在网关路由后写入
+type httpRouteKey struct{}
+
+type httpRoute struct {
+	pattern string
+}
+
+// WithHTTPMetrics 记录网关的请求数、耗时、响应大小和处理中的请求数，
+// route标签为路由模板(需注册MetricsRoute)，包括路由失败、序列化失败等在CustomErrorHandler中返回的请求
+func WithHTTPMetrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HTTPInflightGauge.Inc()
+		defer HTTPInflightGauge.Dec()
+		start := time.Now()
+		route := &httpRoute{}
+		rw := &statusResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), httpRouteKey{}, route)))
+		method := r.Method
+		if !httpMethods[method] {
+			method = "OTHER"
+		}
+		pattern := route.pattern
+		if len(pattern) == 0 {
+			pattern = HTTPRouteOther
+		}
+		code := strconv.Itoa(rw.Status())
+		HTTPRequestCounter.WithLabelValues(method, pattern, code).Inc()
+		HTTPRequestDuration.WithLabelValues(method, pattern, code).Observe(time.Since(start).Seconds())
+		HTTPResponseSize.WithLabelValues(method, pattern, code).Observe(float64(rw.size))
+	})
+}
+
+// HandlePath 同 runtime.ServeMux.HandlePath，并以pattern作为指标的route标签和span名称，
+// mux.HandlePath注册的路由不经过runtime.WithMetadata，route标签为HTTPRouteOther
+func HandlePath(mux *runtime.ServeMux, method, pattern string, h runtime.HandlerFunc) error {
+	return mux.HandlePath(method, pattern, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		if route, ok := r.Context().Value(httpRouteKey{}).(*httpRoute); ok {
+			route.pattern = pattern
+		}
+		setSpanRoute(r.Context(), r.Method, pattern)
+		h(w, r, params)
+	})
+}
+
+// MetricsRoute 网关匹配路由后记录路由模板，如 /v1/users/{id}，通过runtime.WithMetadata注册
+func MetricsRoute(ctx context.Context, r *http.Request) metadata.MD {
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		if route, ok := ctx.Value(httpRouteKey{}).(*httpRoute); ok {
+			route.pattern = pattern
+		}
+	}
+	return nil
+}
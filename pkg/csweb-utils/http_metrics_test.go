@@ -0,0 +1,44 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithHTTPMetrics(t *testing.T) {
+	mux := runtime.NewServeMux(runtime.WithMetadata(MetricsRoute))
+	assert.Nil(t, mux.HandlePath(http.MethodGet, "/v1/users/{id}", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		// 模拟生成的网关代码在路由后注入路由模板
+		_, err := runtime.AnnotateContext(r.Context(), mux, r, "/csweb.User/Get", runtime.WithHTTPPathPattern("/v1/users/{id}"))
+		assert.Nil(t, err)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	// HandlePath注册的路由以注册路径作为route标签
+	assert.Nil(t, HandlePath(mux, http.MethodGet, "/login", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		w.WriteHeader(http.StatusFound)
+	}))
+	handler := WithHTTPMetrics(mux)
+	login := testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, "/login", "302"))
+	matched := testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, "/v1/users/{id}", "200"))
+	unmatched := testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, HTTPRouteOther, "404"))
+	for _, path := range []string{"/v1/users/1", "/v1/users/2", "/v2/unknown", "/login"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/v1/users/1", nil))
+
+	assert.Equal(t, matched+2, testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, "/v1/users/{id}", "200")))
+	assert.Equal(t, unmatched+1, testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, HTTPRouteOther, "404")))
+	assert.Equal(t, login+1, testutil.ToFloat64(HTTPRequestCounter.WithLabelValues(http.MethodGet, "/login", "302")))
+	// 未知的请求方法记为OTHER，不产生新的method标签
+	assert.Equal(t, 4, testutil.CollectAndCount(HTTPRequestCounter, "gateway_http_requests_total"))
+	assert.Equal(t, float64(0), testutil.ToFloat64(HTTPInflightGauge))
+}
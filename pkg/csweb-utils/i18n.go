@@ -0,0 +1,338 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MessageDomain response.go中的错误以ErrorInfo携带消息id时使用的domain
+const MessageDomain = "csweb.message"
+
+// Catalog 多语言消息目录，消息模板中的 {name} 会被替换为对应参数
+//
+// 消息id约定:
+//   - ErrorInfo.Reason，如 QUERY_FAILED、TOKEN_EXPIRED，参数取自ErrorInfo.Metadata
+//   - code.<grpc code>，如 code.NotFound，没有更具体的消息时使用
+//   - validate.<protovalidate constraint id>，如 validate.string.min_len，也可省略类型写作 validate.min_len，
+//     参数 {field} 为字段路径，{0} {1}... 依次为原始英文消息中的数字或反引号中的值
+type Catalog struct {
+	mu       sync.RWMutex
+	fallback language.Tag
+	tags     []language.Tag
+	messages map[language.Tag]map[string]string
+	matcher  language.Matcher
+}
+
+// NewCatalog fallback为无法匹配请求语言时使用的语言
+func NewCatalog(fallback language.Tag) *Catalog {
+	that := &Catalog{fallback: fallback, messages: make(map[language.Tag]map[string]string)}
+	that.Add(fallback, nil)
+	return that
+}
+
+// Add 添加或覆盖某个语言的消息
+func (that *Catalog) Add(tag language.Tag, messages map[string]string) *Catalog {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if _, ok := that.messages[tag]; !ok {
+		that.messages[tag] = make(map[string]string)
+		that.tags = append(that.tags, tag)
+		that.matcher = language.NewMatcher(that.tags)
+	}
+	for id, msg := range messages {
+		that.messages[tag][id] = msg
+	}
+	return that
+}
+
+// Match 根据Accept-Language选择目录中最合适的语言
+func (that *Catalog) Match(acceptLanguage ...string) language.Tag {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	desired := make([]language.Tag, 0)
+	for _, accept := range acceptLanguage {
+		tags, _, err := language.ParseAcceptLanguage(accept)
+		if err == nil {
+			desired = append(desired, tags...)
+		}
+	}
+	if len(desired) == 0 {
+		return that.fallback
+	}
+	_, index, confidence := that.matcher.Match(desired...)
+	if confidence == language.No {
+		return that.fallback
+	}
+	return that.tags[index]
+}
+
+// Format 返回指定语言的消息，该语言没有此消息时使用fallback语言
+func (that *Catalog) Format(tag language.Tag, id string, params map[string]string) (string, bool) {
+	msg, _, ok := that.format(tag, id, params)
+	return msg, ok
+}
+
+// format 同Format，并返回消息实际使用的语言
+func (that *Catalog) format(tag language.Tag, id string, params map[string]string) (string, language.Tag, bool) {
+	that.mu.RLock()
+	defer that.mu.RUnlock()
+	tmpl, ok := that.messages[tag][id]
+	if !ok {
+		if tmpl, ok = that.messages[that.fallback][id]; !ok {
+			return "", tag, false
+		}
+		tag = that.fallback
+	}
+	for k, v := range params {
+		tmpl = strings.ReplaceAll(tmpl, "{"+k+"}", v)
+	}
+	return tmpl, tag, true
+}
+
+// DefaultCatalog 内置的中英文消息
+var DefaultCatalog = NewCatalog(language.English).
+	Add(language.English, map[string]string{
+		"QUERY_FAILED":            "query {obj} failed, try again later",
+		"UPDATE_FAILED":           "update {obj} failed, try again later",
+		"DELETE_FAILED":           "delete {obj} failed, try again later",
+		"TOKEN_EXPIRED":           "your session has expired, please log in again",
+		"code.InvalidArgument":    "invalid request parameters",
+		"code.NotFound":           "resource not found",
+		"code.PermissionDenied":   "permission denied",
+		"code.Unauthenticated":    "please log in first",
+		"code.ResourceExhausted":  "too many requests, please try again later",
+		"code.Internal":           "internal error, please try again later",
+		"code.Unavailable":        "service unavailable, please try again later",
+		"code.DeadlineExceeded":   "request timed out, please try again later",
+		"code.FailedPrecondition": "the operation is not allowed in the current state",
+		"code.AlreadyExists":      "resource already exists",
+		"code.Unimplemented":      "operation not supported",
+		"code.Canceled":           "request canceled",
+		"code.Aborted":            "operation aborted, please try again",
+		"code.OutOfRange":         "parameter out of range",
+		"code.DataLoss":           "internal error, please try again later",
+		"code.Unknown":            "internal error, please try again later",
+	}).
+	Add(language.Chinese, map[string]string{
+		"QUERY_FAILED":            "查询{obj}失败，请稍后重试",
+		"UPDATE_FAILED":           "更新{obj}失败，请稍后重试",
+		"DELETE_FAILED":           "删除{obj}失败，请稍后重试",
+		"TOKEN_EXPIRED":           "登录已过期，请重新登录",
+		"code.InvalidArgument":    "请求参数错误",
+		"code.NotFound":           "资源不存在",
+		"code.PermissionDenied":   "没有权限",
+		"code.Unauthenticated":    "请先登录",
+		"code.ResourceExhausted":  "请求过于频繁，请稍后重试",
+		"code.Internal":           "服务内部错误，请稍后重试",
+		"code.Unavailable":        "服务暂不可用，请稍后重试",
+		"code.DeadlineExceeded":   "请求超时，请稍后重试",
+		"code.FailedPrecondition": "当前状态不允许该操作",
+		"code.AlreadyExists":      "资源已存在",
+		"code.Unimplemented":      "不支持该操作",
+		"code.Canceled":           "请求已取消",
+		"code.Aborted":            "操作冲突，请重试",
+		"code.OutOfRange":         "参数超出范围",
+		"code.DataLoss":           "服务内部错误，请稍后重试",
+		"code.Unknown":            "服务内部错误，请稍后重试",
+		"validate.required":       "{field}不能为空",
+		"validate.const":          "{field}必须等于{0}",
+		"validate.gt":             "{field}必须大于{0}",
+		"validate.gte":            "{field}必须大于或等于{0}",
+		"validate.lt":             "{field}必须小于{0}",
+		"validate.lte":            "{field}必须小于或等于{0}",
+		"validate.gt_lt":          "{field}必须大于{0}且小于{1}",
+		"validate.gt_lte":         "{field}必须大于{0}且小于或等于{1}",
+		"validate.gte_lt":         "{field}必须大于或等于{0}且小于{1}",
+		"validate.gte_lte":        "{field}必须大于或等于{0}且小于或等于{1}",
+		"validate.in":             "{field}必须是允许的值之一",
+		"validate.not_in":         "{field}不能是禁止的值",
+		"validate.defined_only":   "{field}必须是已定义的枚举值",
+		"validate.len":            "{field}的长度必须为{0}",
+		"validate.min_len":        "{field}的长度不能小于{0}",
+		"validate.max_len":        "{field}的长度不能大于{0}",
+		"validate.len_bytes":      "{field}的字节数必须为{0}",
+		"validate.min_bytes":      "{field}的字节数不能小于{0}",
+		"validate.max_bytes":      "{field}的字节数不能大于{0}",
+		"validate.pattern":        "{field}格式不正确",
+		"validate.prefix":         "{field}必须以{0}开头",
+		"validate.suffix":         "{field}必须以{0}结尾",
+		"validate.contains":       "{field}必须包含{0}",
+		"validate.not_contains":   "{field}不能包含{0}",
+		"validate.email":          "{field}必须是有效的邮箱地址",
+		"validate.hostname":       "{field}必须是有效的主机名",
+		"validate.ip":             "{field}必须是有效的IP地址",
+		"validate.ipv4":           "{field}必须是有效的IPv4地址",
+		"validate.ipv6":           "{field}必须是有效的IPv6地址",
+		"validate.uri":            "{field}必须是有效的URI",
+		"validate.uri_ref":        "{field}必须是有效的URI引用",
+		"validate.uuid":           "{field}必须是有效的UUID",
+		"validate.min_items":      "{field}至少需要{0}项",
+		"validate.max_items":      "{field}最多允许{0}项",
+		"validate.unique":         "{field}中的元素不能重复",
+		"validate.min_pairs":      "{field}至少需要{0}项",
+		"validate.max_pairs":      "{field}最多允许{0}项",
+		"validate.cn_mobile":      "{field}必须是有效的手机号",
+		"validate.e164":           "{field}必须是E.164格式的电话号码",
+		"validate.slug":           "{field}只能包含小写字母、数字和连字符",
+	})
+
+type localeKey struct{}
+
+type catalogKey struct{}
+
+// SetLocaleWithContext ctx传递请求的语言
+func SetLocaleWithContext(ctx context.Context, tag language.Tag) context.Context {
+	return context.WithValue(ctx, localeKey{}, tag)
+}
+
+// LocaleFromContext 获取请求的语言，未经过WithI18n时根据metadata中的Accept-Language与DefaultCatalog协商
+func LocaleFromContext(ctx context.Context) language.Tag {
+	if tag, ok := ctx.Value(localeKey{}).(language.Tag); ok {
+		return tag
+	}
+	return catalogFromContext(ctx).Match(acceptLanguage(ctx)...)
+}
+
+func catalogFromContext(ctx context.Context) *Catalog {
+	if catalog, ok := i18nCatalog(ctx); ok {
+		return catalog
+	}
+	return DefaultCatalog
+}
+
+// i18nCatalog WithI18n设置的消息目录，未启用WithI18n时返回false
+func i18nCatalog(ctx context.Context) (*Catalog, bool) {
+	catalog, ok := ctx.Value(catalogKey{}).(*Catalog)
+	return catalog, ok
+}
+
+// acceptLanguage grpc调用方的accept-language，或网关以grpcgateway-前缀透传的Accept-Language
+func acceptLanguage(ctx context.Context) []string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	return append(md.Get("accept-language"), md.Get(runtime.MetadataPrefix+"accept-language")...)
+}
+
+// Localize 按请求的语言格式化消息，消息不存在时返回id
+func Localize(ctx context.Context, id string, params map[string]string) string {
+	if msg, ok := catalogFromContext(ctx).Format(LocaleFromContext(ctx), id, params); ok {
+		return msg
+	}
+	return id
+}
+
+// messageError 返回携带消息id的错误，WithI18n根据id为其添加LocalizedMessage，kv为模板参数
+func messageError(code codes.Code, reason, msg string, kv ...string) error {
+	params := make(map[string]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		params[kv[i]] = kv[i+1]
+	}
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   MessageDomain,
+		Metadata: params,
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// localizeError 为status错误添加请求语言的LocalizedMessage，Locale为消息实际使用的语言(可能是fallback语言)，
+// 已有LocalizedMessage或无对应消息时原样返回
+func localizeError(catalog *Catalog, tag language.Tag, err error) error {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.OK {
+		return err
+	}
+	msg, matched, found := "", tag, false
+	for _, detail := range s.Details() {
+		switch d := detail.(type) {
+		case *errdetails.LocalizedMessage:
+			return err
+		case *errdetails.ErrorInfo:
+			if !found {
+				msg, matched, found = catalog.format(tag, d.GetReason(), d.GetMetadata())
+			}
+		}
+	}
+	if !found {
+		if msg, matched, found = catalog.format(tag, "code."+s.Code().String(), nil); !found {
+			return err
+		}
+	}
+	localized, detailErr := s.WithDetails(&errdetails.LocalizedMessage{Locale: matched.String(), Message: msg})
+	if detailErr != nil {
+		return err
+	}
+	return localized.Err()
+}
+
+// violationParams 原始英文消息中的数字及反引号中的值，依次作为 {0} {1}...
+var violationParams = regexp.MustCompile("`([^`]*)`|(-?\\d+(?:\\.\\d+)?)")
+
+// localizeViolation 翻译protovalidate的字段错误，没有对应模板时返回原始消息
+func localizeViolation(catalog *Catalog, tag language.Tag, field, constraintId, message string) string {
+	params := map[string]string{"field": field}
+	for i, m := range violationParams.FindAllStringSubmatch(message, -1) {
+		v := m[1]
+		if len(v) == 0 {
+			v = m[2]
+		}
+		params[strconv.Itoa(i)] = v
+	}
+	if msg, ok := catalog.formatExact(tag, "validate."+constraintId, params); ok {
+		return msg
+	}
+	if _, rule, ok := strings.Cut(constraintId, "."); ok {
+		if msg, ok := catalog.formatExact(tag, "validate."+rule, params); ok {
+			return msg
+		}
+	}
+	return message
+}
+
+// formatExact 仅使用指定语言的消息，不回退到fallback语言
+func (that *Catalog) formatExact(tag language.Tag, id string, params map[string]string) (string, bool) {
+	that.mu.RLock()
+	_, ok := that.messages[tag][id]
+	that.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return that.Format(tag, id, params)
+}
+
+// WithI18n return a new unary server interceptor that negotiates the request locale from Accept-Language
+// and attaches a LocalizedMessage detail to the returned status error.
+func WithI18n(catalog *Catalog) grpc.UnaryServerInterceptor {
+	if catalog == nil {
+		catalog = DefaultCatalog
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		tag := catalog.Match(acceptLanguage(ctx)...)
+		ctx = context.WithValue(SetLocaleWithContext(ctx, tag), catalogKey{}, catalog)
+		resp, err := handler(ctx, req)
+		if err != nil {
+			err = localizeError(catalog, tag, err)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stonejianbu/csweb/protos/common"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestCatalog_Match(t *testing.T) {
+	assert.Equal(t, language.Chinese, DefaultCatalog.Match("zh-CN,zh;q=0.9,en;q=0.8"))
+	assert.Equal(t, language.English, DefaultCatalog.Match("en-US"))
+	assert.Equal(t, language.English, DefaultCatalog.Match("fr-FR"))
+	assert.Equal(t, language.English, DefaultCatalog.Match())
+	assert.Equal(t, language.Chinese, DefaultCatalog.Match("ja;q=0.9,zh-TW;q=0.8"))
+
+	msg, ok := DefaultCatalog.Format(language.Chinese, "QUERY_FAILED", map[string]string{"obj": "订单"})
+	assert.True(t, ok)
+	assert.Equal(t, "查询订单失败，请稍后重试", msg)
+	_, ok = DefaultCatalog.Format(language.Chinese, "UNKNOWN", nil)
+	assert.False(t, ok)
+}
+
+func TestWithI18n(t *testing.T) {
+	catalog := NewCatalog(language.English).Add(language.English, map[string]string{"code.NotFound": "not found"})
+	catalog.Add(language.Japanese, map[string]string{"code.NotFound": "見つかりません"})
+	interceptor := WithI18n(catalog)
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Get"}
+	localized := func(ctx context.Context, handlerErr error) *errdetails.LocalizedMessage {
+		_, err := interceptor(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			return nil, handlerErr
+		})
+		for _, detail := range status.Convert(err).Details() {
+			if msg, ok := detail.(*errdetails.LocalizedMessage); ok {
+				return msg
+			}
+		}
+		return nil
+	}
+	// 经网关透传的Accept-Language
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("grpcgateway-accept-language", "ja-JP"))
+	msg := localized(ctx, NotFoundError(assert.AnError))
+	assert.Equal(t, "ja", msg.GetLocale())
+	assert.Equal(t, "見つかりません", msg.GetMessage())
+	// 目录中没有的消息不添加LocalizedMessage
+	assert.Nil(t, localized(ctx, status.Error(codes.Internal, "boom")))
+	// 请求语言没有该消息时Locale为实际使用的fallback语言
+	catalog.Add(language.English, map[string]string{"code.Internal": "internal error"})
+	msg = localized(ctx, status.Error(codes.Internal, "boom"))
+	assert.Equal(t, "en", msg.GetLocale())
+	assert.Equal(t, "internal error", msg.GetMessage())
+
+	// 内置目录翻译response.go中的错误
+	interceptor = WithI18n(nil)
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "zh-CN"))
+	assert.Equal(t, "查询order失败，请稍后重试", localized(ctx, QueryError("order")).GetMessage())
+	assert.Equal(t, "请求参数错误", localized(ctx, ArgumentError("bad id")).GetMessage())
+	assert.Equal(t, "登录已过期，请重新登录", localized(ctx, TokenExpired).GetMessage())
+}
+
+func TestWithValidator_Localized(t *testing.T) {
+	validate := func(ctx context.Context, interceptors ...grpc.UnaryServerInterceptor) error {
+		handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+		info := &grpc.UnaryServerInfo{}
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := handler, interceptors[i]
+			handler = func(ctx context.Context, req any) (any, error) { return interceptor(ctx, req, info, next) }
+		}
+		_, err := handler(ctx, &common.Page{Page: 0, PerPage: 10})
+		return err
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("grpcgateway-accept-language", "zh-CN"))
+	err := validate(ctx, WithI18n(nil), WithValidator())
+	w := httptest.NewRecorder()
+	CustomErrorHandler(context.Background(), runtime.NewServeMux(), &runtime.JSONPb{}, w, httptest.NewRequest(http.MethodGet, "/", nil), err)
+	errResp := ErrResp{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "请求参数错误", errResp.Status.LocalizedMessage)
+	assert.Equal(t, "page必须大于或等于1", errResp.FieldErrors[0].Message)
+
+	// 英文保留protovalidate的原始消息
+	err = validate(context.Background(), WithI18n(nil), WithValidator())
+	badRequest := status.Convert(err).Details()[0].(*errdetails.BadRequest)
+	assert.Equal(t, "value must be greater than or equal to 1", badRequest.GetFieldViolations()[0].GetDescription())
+
+	// 未启用WithI18n时不翻译，也不添加LocalizedMessage
+	err = validate(ctx, WithValidator())
+	assert.Len(t, status.Convert(err).Details(), 1)
+	badRequest = status.Convert(err).Details()[0].(*errdetails.BadRequest)
+	assert.Equal(t, "value must be greater than or equal to 1", badRequest.GetFieldViolations()[0].GetDescription())
+}
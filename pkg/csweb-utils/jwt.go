@@ -6,30 +6,54 @@ package csweb_utils
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"slices"
-	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 const OneDayTimestamp = 86400
 
+// TokenErrorDomain token校验失败时ErrorInfo的domain
+const TokenErrorDomain = "csweb.jwt"
+
 var (
-	TokenExpired     = errors.New("expired token")
-	TokenNotValidYet = errors.New("unactivated token")
-	TokenMalformed   = errors.New("unknown token")
-	TokenInvalid     = errors.New("invalid token")
+	TokenExpired          = tokenError("TOKEN_EXPIRED", "expired token")
+	TokenNotValidYet      = tokenError("TOKEN_NOT_VALID_YET", "unactivated token")
+	TokenMalformed        = tokenError("TOKEN_MALFORMED", "unknown token")
+	TokenInvalid          = tokenError("TOKEN_INVALID", "invalid token")
+	TokenSignatureInvalid = tokenError("TOKEN_SIGNATURE_INVALID", "invalid token signature or algorithm")
+	TokenIssuerInvalid    = tokenError("TOKEN_ISSUER_INVALID", "invalid token issuer")
+	TokenAudienceInvalid  = tokenError("TOKEN_AUDIENCE_INVALID", "invalid token audience")
 )
 
+// tokenError 返回Unauthenticated状态的错误，并以ErrorInfo.Reason区分失败原因
+func tokenError(reason, msg string) error {
+	st, err := status.New(codes.Unauthenticated, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: TokenErrorDomain,
+	})
+	if err != nil {
+		return status.Error(codes.Unauthenticated, msg)
+	}
+	return st.Err()
+}
+
 type JWT struct {
 	SigningKey []byte
+	Issuer     string        // 签发者，非空时CreateToken默认写入并在ParseToken时校验
+	Audience   []string      // 接收方，非空时CreateToken默认写入并在ParseToken时校验token包含其中之一
+	Leeway     time.Duration // 校验exp/nbf/iat时允许的时钟偏差
 }
 
 type CustomClaims struct {
@@ -37,68 +61,157 @@ type CustomClaims struct {
 	Username    string
 	NickName    string
 	AuthorityId string
-	jwt.StandardClaims
+	TenantId    string          `json:"TenantId,omitempty"`
+	Scopes      []string        `json:"Scopes,omitempty"`
+	Custom      json.RawMessage `json:"Custom,omitempty"` // 业务自定义字段，通过 SetCustom/GetCustom 读写
+	jwt.RegisteredClaims
+}
+
+// SetCustom 将业务自定义字段序列化写入claims
+func (c *CustomClaims) SetCustom(v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Custom = buf
+	return nil
+}
+
+// HasScope claims是否包含指定scope
+func (c *CustomClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// GetCustom 将claims中的业务自定义字段解析为T
+func GetCustom[T any](c *CustomClaims) (T, bool) {
+	var v T
+	if c == nil || len(c.Custom) == 0 {
+		return v, false
+	}
+	if err := json.Unmarshal(c.Custom, &v); err != nil {
+		return v, false
+	}
+	return v, true
 }
 
 func NewJWT(signKey string) *JWT {
 	return &JWT{
-		[]byte(signKey),
+		SigningKey: []byte(signKey),
 	}
 }
 
 // CreateToken 创建一个token
 func (j *JWT) CreateToken(claims CustomClaims) (string, error) {
-	claims.NotBefore = time.Now().Unix() - 100
-	if claims.ExpiresAt == 0 {
-		claims.ExpiresAt = time.Now().Unix() + OneDayTimestamp
+	now := time.Now()
+	claims.NotBefore = jwt.NewNumericDate(now.Add(-100 * time.Second))
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(OneDayTimestamp * time.Second))
+	}
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if len(claims.Issuer) == 0 {
+		claims.Issuer = j.Issuer
+	}
+	if len(claims.Audience) == 0 {
+		claims.Audience = j.Audience
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
 	return token.SignedString(j.SigningKey)
 }
 
-// ParseToken 解析 token
+// ParseToken 解析 token，仅允许HS256签名算法，防止alg混淆攻击
 func (j *JWT) ParseToken(tokenString string) (*CustomClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (i interface{}, e error) {
-		return j.SigningKey, nil
-	})
-	if err != nil {
-		if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-				return nil, TokenMalformed
-			} else if ve.Errors&jwt.ValidationErrorExpired != 0 {
-				// Token is expired
-				return nil, TokenExpired
-			} else if ve.Errors&jwt.ValidationErrorNotValidYet != 0 {
-				return nil, TokenNotValidYet
-			} else {
-				return nil, TokenInvalid
-			}
-		}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(j.Leeway),
+	}
+	if len(j.Issuer) > 0 {
+		opts = append(opts, jwt.WithIssuer(j.Issuer))
 	}
-	if token != nil {
-		if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-			return claims, nil
+	if len(j.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(j.Audience...))
+	}
+	claims := &CustomClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (i interface{}, e error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrTokenSignatureInvalid
 		}
+		return j.SigningKey, nil
+	}, opts...)
+	switch {
+	case err == nil && token.Valid:
+		return claims, nil
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return nil, TokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return nil, TokenSignatureInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, TokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return nil, TokenNotValidYet
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return nil, TokenIssuerInvalid
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return nil, TokenAudienceInvalid
+	default:
 		return nil, TokenInvalid
-
-	} else {
-		return nil, TokenInvalid
-
 	}
 }
 
-var ClaimsKey struct{}
+type claimsKey struct{}
+
+var ClaimsKey = claimsKey{}
 
 func SetClaimsWithContext(ctx context.Context, claims *CustomClaims) context.Context {
 	return context.WithValue(ctx, ClaimsKey, claims)
 }
 
+// ClaimsFromContext 获取ctx中的claims，未鉴权或免鉴权的方法返回false
+func ClaimsFromContext(ctx context.Context) (*CustomClaims, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*CustomClaims)
+	return claims, ok && claims != nil
+}
+
+// CustomFromContext 获取ctx中claims的业务自定义字段
+func CustomFromContext[T any](ctx context.Context) (T, bool) {
+	claims, _ := ClaimsFromContext(ctx)
+	return GetCustom[T](claims)
+}
+
+// UserNameFromContext 获取ctx中claims的Username
+func UserNameFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return claims.Username, true
+}
+
+// TenantIdFromContext 获取ctx中claims的TenantId
+func TenantIdFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || len(claims.TenantId) == 0 {
+		return "", false
+	}
+	return claims.TenantId, true
+}
+
+// GetClaims 获取ctx中的claims，不存在时返回nil
+//
+// Deprecated: use ClaimsFromContext.
 func GetClaims(ctx context.Context) *CustomClaims {
-	return ctx.Value(ClaimsKey).(*CustomClaims)
+	claims, _ := ClaimsFromContext(ctx)
+	return claims
 }
 
+// GetUserName 获取ctx中的Username，不存在时返回空字符串
+//
+// Deprecated: use UserNameFromContext.
 func GetUserName(ctx context.Context) string {
-	return ctx.Value(ClaimsKey).(*CustomClaims).Username
+	username, _ := UserNameFromContext(ctx)
+	return username
 }
 
 // CookieToAuth Specifies that the value of the cookie key is converted to the value of the header Authorization
@@ -109,35 +222,52 @@ func CookieToAuth(cookieKey string) func(ctx context.Context, req *http.Request)
 		if len(req.Header.Get("Authorization")) != 0 {
 			return md
 		}
-		tokenCookieKey := cookieKey
-		for _, item := range strings.Split(req.Header.Get("Cookie"), ";") {
-			temp := strings.Split(item, "=")
-			if len(temp) == 2 {
-				if temp[0] == tokenCookieKey {
-					md = metadata.Pairs("Authorization", fmt.Sprintf("bearer %s", temp[1]))
-				}
-			}
+		if cookie, err := req.Cookie(cookieKey); err == nil && len(cookie.Value) > 0 {
+			md = metadata.Pairs("Authorization", fmt.Sprintf("bearer %s", cookie.Value))
 		}
 		return md
 	}
 }
 
 func WithJwtAuth(signKey string, filterMethods ...string) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if slices.Contains(filterMethods, info.FullMethod) {
-			return handler(ctx, req)
-		}
-		var err error
-		token, err := auth.AuthFromMD(ctx, "bearer")
-		if err != nil {
-			return nil, err
-		}
-		j := NewJWT(signKey)
-		claims, err := j.ParseToken(token)
-		if err != nil {
-			return nil, err
-		}
-		ctx = SetClaimsWithContext(ctx, claims)
-		return handler(ctx, req)
+	return WithJwtAuthPolicy(NewJWT(signKey), NewAuthPolicy(false, filterMethods...))
+}
+
+// WithJwtAuthPolicy return a new unary server interceptor that performs jwt auth according to the AuthPolicy.
+func WithJwtAuthPolicy(j *JWT, policy *AuthPolicy) grpc.UnaryServerInterceptor {
+	return WithAuth(policy, NewJwtAuthenticator(j))
+}
+
+// JwtAuthenticator 通过Authorization: bearer <token> 认证调用方
+type JwtAuthenticator struct {
+	jwt *JWT
+}
+
+func NewJwtAuthenticator(j *JWT) *JwtAuthenticator {
+	return &JwtAuthenticator{jwt: j}
+}
+
+func (that *JwtAuthenticator) Kind() string {
+	return CredentialJwt
+}
+
+func (that *JwtAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	if len(metadata.ValueFromIncomingContext(ctx, "authorization")) == 0 {
+		return ctx, ErrNoCredential
+	}
+	token, err := auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return ctx, err
+	}
+	claims, err := that.jwt.ParseToken(token)
+	if err != nil {
+		return ctx, err
 	}
+	ctx = SetClaimsWithContext(ctx, claims)
+	return SetPrincipalWithContext(ctx, &Principal{
+		Kind:  CredentialJwt,
+		Id:    claims.UID,
+		Name:  claims.Username,
+		Roles: []string{claims.AuthorityId},
+	}), nil
 }
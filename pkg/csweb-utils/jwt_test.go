@@ -5,11 +5,12 @@
 package csweb_utils
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,10 +22,10 @@ func TestJWT_CreateToken(t *testing.T) {
 		NickName:    "stonejianbu",
 		Username:    "stone",
 		AuthorityId: "1000",
-		StandardClaims: jwt.StandardClaims{
-			NotBefore: time.Now().Unix() - 1000,  // 签名生效时间
-			ExpiresAt: time.Now().Unix() + 86400, // 过期时间 1天
-			Issuer:    "qmPlus",                  // 签名的发行者
+		RegisteredClaims: jwt.RegisteredClaims{
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-1000 * time.Second)), // 签名生效时间
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(86400 * time.Second)), // 过期时间 1天
+			Issuer:    "qmPlus",                                                // 签名的发行者
 		},
 	})
 	assert.Nil(t, err)
@@ -38,3 +39,98 @@ func TestJWT_CreateToken(t *testing.T) {
 	assert.Nil(t, err)
 	fmt.Printf("ret == %#v\n", parseToken)
 }
+
+func TestJWT_CustomClaims(t *testing.T) {
+	type tenantInfo struct {
+		Plan  string
+		Quota int
+	}
+	jwtObj := NewJWT("hello")
+	claims := CustomClaims{UID: "123456", TenantId: "t-1", Scopes: []string{"order:read"}}
+	assert.Nil(t, claims.SetCustom(tenantInfo{Plan: "pro", Quota: 100}))
+	token, err := jwtObj.CreateToken(claims)
+	assert.Nil(t, err)
+
+	parsed, err := jwtObj.ParseToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "t-1", parsed.TenantId)
+	assert.True(t, parsed.HasScope("order:read"))
+	assert.False(t, parsed.HasScope("order:write"))
+
+	ctx := SetClaimsWithContext(context.Background(), parsed)
+	info, ok := CustomFromContext[tenantInfo](ctx)
+	assert.True(t, ok)
+	assert.Equal(t, tenantInfo{Plan: "pro", Quota: 100}, info)
+	tenantId, ok := TenantIdFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "t-1", tenantId)
+}
+
+func TestClaimsFromContext_Unauthenticated(t *testing.T) {
+	ctx := context.Background()
+	_, ok := ClaimsFromContext(ctx)
+	assert.False(t, ok)
+	_, ok = UserNameFromContext(ctx)
+	assert.False(t, ok)
+	_, ok = CustomFromContext[map[string]any](ctx)
+	assert.False(t, ok)
+	assert.Nil(t, GetClaims(ctx))
+	assert.Equal(t, "", GetUserName(ctx))
+}
+
+func TestJWT_ParseTokenValidation(t *testing.T) {
+	issuer := &JWT{SigningKey: []byte("hello"), Issuer: "csweb", Audience: []string{"order"}}
+	token, err := issuer.CreateToken(CustomClaims{UID: "123456"})
+	assert.Nil(t, err)
+	_, err = issuer.ParseToken(token)
+	assert.Nil(t, err)
+
+	// issuer不匹配
+	other := &JWT{SigningKey: []byte("hello"), Issuer: "other"}
+	_, err = other.ParseToken(token)
+	assert.ErrorIs(t, err, TokenIssuerInvalid)
+
+	// audience不匹配
+	other = &JWT{SigningKey: []byte("hello"), Audience: []string{"user"}}
+	_, err = other.ParseToken(token)
+	assert.ErrorIs(t, err, TokenAudienceInvalid)
+
+	// 签名密钥不一致
+	other = NewJWT("world")
+	_, err = other.ParseToken(token)
+	assert.ErrorIs(t, err, TokenSignatureInvalid)
+
+	// 格式错误
+	_, err = issuer.ParseToken("not-a-token")
+	assert.ErrorIs(t, err, TokenMalformed)
+}
+
+func TestJWT_ParseTokenAlgorithm(t *testing.T) {
+	jwtObj := NewJWT("hello")
+	claims := &CustomClaims{UID: "123456"}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	// alg=none
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.Nil(t, err)
+	_, err = jwtObj.ParseToken(token)
+	assert.ErrorIs(t, err, TokenSignatureInvalid)
+	// 非白名单的HMAC算法
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(jwtObj.SigningKey)
+	assert.Nil(t, err)
+	_, err = jwtObj.ParseToken(token)
+	assert.ErrorIs(t, err, TokenSignatureInvalid)
+}
+
+func TestJWT_ParseTokenLeeway(t *testing.T) {
+	jwtObj := NewJWT("hello")
+	claims := CustomClaims{UID: "123456"}
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-5 * time.Second))
+	token, err := jwtObj.CreateToken(claims)
+	assert.Nil(t, err)
+	_, err = jwtObj.ParseToken(token)
+	assert.ErrorIs(t, err, TokenExpired)
+
+	jwtObj.Leeway = 30 * time.Second
+	_, err = jwtObj.ParseToken(token)
+	assert.Nil(t, err)
+}
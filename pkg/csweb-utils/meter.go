@@ -0,0 +1,125 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+const (
+	MetricExporterNone     = "none"
+	MetricExporterOTLPGrpc = "otlpgrpc" // Endpoint为 host:4317 或 URL
+	MetricExporterOTLPHttp = "otlphttp" // Endpoint为 host:4318 或 URL
+)
+
+// MeterConfig OpenTelemetry指标配置，Prometheus和OTLP可同时启用
+type MeterConfig struct {
+	Prometheus bool              // 通过/metrics暴露OpenTelemetry指标，需要同时启用WithMetrics
+	Exporter   string            // OTLP推送方式，默认none
+	Endpoint   string            // OTLP地址，为空时使用OTEL_EXPORTER_OTLP_ENDPOINT等环境变量
+	Insecure   bool              // OTLP不使用TLS
+	Headers    map[string]string // OTLP请求头
+	Interval   time.Duration     // OTLP推送间隔，默认60s
+}
+
+// newMetricReaders 按配置创建Prometheus和OTLP的Reader，先创建OTLP exporter，
+// 避免其配置错误时Prometheus exporter已注册到registerer
+func newMetricReaders(conf MeterConfig, registerer prometheus.Registerer) ([]sdkmetric.Reader, error) {
+	readers := make([]sdkmetric.Reader, 0)
+	exporter, err := newMetricExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	if exporter != nil {
+		periodicOpts := make([]sdkmetric.PeriodicReaderOption, 0)
+		if conf.Interval > 0 {
+			periodicOpts = append(periodicOpts, sdkmetric.WithInterval(conf.Interval))
+		}
+		readers = append(readers, sdkmetric.NewPeriodicReader(exporter, periodicOpts...))
+	}
+	if conf.Prometheus {
+		promExporter, err := otelprom.New(otelprom.WithRegisterer(registerer))
+		if err != nil {
+			if exporter != nil {
+				_ = exporter.Shutdown(context.Background())
+			}
+			return nil, err
+		}
+		readers = append(readers, promExporter)
+	}
+	return readers, nil
+}
+
+// newMetricExporter 按配置创建OTLP exporter，未启用时返回nil
+func newMetricExporter(conf MeterConfig) (sdkmetric.Exporter, error) {
+	ctx := context.Background()
+	isURL := strings.Contains(conf.Endpoint, "://")
+	switch conf.Exporter {
+	case "", MetricExporterNone:
+		return nil, nil
+	case MetricExporterOTLPGrpc:
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(conf.Headers)}
+		if isURL {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(conf.Endpoint))
+		} else if len(conf.Endpoint) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	case MetricExporterOTLPHttp:
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(conf.Headers)}
+		if isURL {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(conf.Endpoint))
+		} else if len(conf.Endpoint) > 0 {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown metric exporter %q", conf.Exporter)
+	}
+}
+
+// NewMeterProvider 按配置创建MeterProvider，Prometheus指标注册到registerer，调用方负责在退出时Shutdown
+func NewMeterProvider(name string, conf MeterConfig, registerer prometheus.Registerer) (*sdkmetric.MeterProvider, error) {
+	res, err := newResource(name)
+	if err != nil {
+		return nil, err
+	}
+	readers, err := newMetricReaders(conf, registerer)
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, reader := range readers {
+		opts = append(opts, sdkmetric.WithReader(reader))
+	}
+	return sdkmetric.NewMeterProvider(opts...), nil
+}
+
+// InitMeter 创建并设置全局的MeterProvider，otelgrpc的rpc.server.*指标及otel.Meter创建的指标都通过它导出，
+// Prometheus指标注册到registerer，返回的shutdown用于退出时推送剩余的指标
+func InitMeter(name string, conf MeterConfig, registerer prometheus.Registerer) (shutdown func(context.Context) error, err error) {
+	mp, err := NewMeterProvider(name, conf, registerer)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetMeterProvider(mp)
+	return mp.Shutdown, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+func TestNewMeterProvider_Prometheus(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	mp, err := NewMeterProvider("test", MeterConfig{Prometheus: true}, registry)
+	assert.Nil(t, err)
+	defer mp.Shutdown(context.Background())
+
+	counter, err := mp.Meter("test").Int64Counter("orders_created")
+	assert.Nil(t, err)
+	counter.Add(context.Background(), 2)
+
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	names := make([]string, 0)
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	assert.Contains(t, names, "orders_created_total")
+
+	_, err = NewMeterProvider("test", MeterConfig{Exporter: "statsd"}, registry)
+	assert.NotNil(t, err)
+	// OTLP配置错误时不会注册Prometheus exporter，修正配置后可以重新创建
+	registry = prometheus.NewRegistry()
+	_, err = NewMeterProvider("test", MeterConfig{Prometheus: true, Exporter: "statsd"}, registry)
+	assert.NotNil(t, err)
+	mp, err = NewMeterProvider("test", MeterConfig{Prometheus: true}, registry)
+	assert.Nil(t, err)
+	defer mp.Shutdown(context.Background())
+}
+
+func TestNewResource(t *testing.T) {
+	res, err := newResource("order")
+	assert.Nil(t, err)
+	attrs := res.Set()
+	name, ok := attrs.Value(semconv.ServiceNameKey)
+	assert.True(t, ok)
+	assert.Equal(t, "order", name.AsString())
+	// 合并了resource.Default()中的sdk属性
+	_, ok = attrs.Value(semconv.TelemetrySDKLanguageKey)
+	assert.True(t, ok)
+}
@@ -6,18 +6,32 @@ package csweb_utils
 
 import (
 	"context"
+	"errors"
+	"reflect"
 
 	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 )
 
-var SrvMetrics = grpcprom.NewServerMetrics(
-	grpcprom.WithServerHandlingTimeHistogram(
-		grpcprom.WithHistogramBuckets([]float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120}),
-	),
-)
+// DefaultHandlingTimeBuckets grpc请求耗时直方图的默认分桶(秒)
+var DefaultHandlingTimeBuckets = []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120}
+
+// SrvMetrics 使用默认分桶的grpc指标，App使用WithMetricsBuckets时会创建自己的ServerMetrics
+var SrvMetrics = NewServerMetrics(nil)
+
+// NewServerMetrics 创建grpc服务端指标，buckets为空时使用DefaultHandlingTimeBuckets
+func NewServerMetrics(buckets []float64) *grpcprom.ServerMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultHandlingTimeBuckets
+	}
+	return grpcprom.NewServerMetrics(
+		grpcprom.WithServerHandlingTimeHistogram(grpcprom.WithHistogramBuckets(buckets)),
+	)
+}
 
 var PanicCounterMetrics = prometheus.NewCounter(prometheus.CounterOpts{
 	Name: "grpc_req_panics_recovered_total",
@@ -25,7 +39,12 @@ var PanicCounterMetrics = prometheus.NewCounter(prometheus.CounterOpts{
 })
 
 func WithMetrics() grpc.UnaryServerInterceptor {
-	return SrvMetrics.UnaryServerInterceptor(grpcprom.WithExemplarFromContext(func(ctx context.Context) prometheus.Labels {
+	return WithServerMetrics(SrvMetrics)
+}
+
+// WithServerMetrics return a new unary server interceptor that records metrics into srvMetrics, with trace exemplars
+func WithServerMetrics(srvMetrics *grpcprom.ServerMetrics) grpc.UnaryServerInterceptor {
+	return srvMetrics.UnaryServerInterceptor(grpcprom.WithExemplarFromContext(func(ctx context.Context) prometheus.Labels {
 		if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
 			return prometheus.Labels{
 				"traceId": span.TraceID().String(),
@@ -36,6 +55,53 @@ func WithMetrics() grpc.UnaryServerInterceptor {
 	}))
 }
 
+// NewMetricsRegistry 创建独立的registry，并注册Go运行时和进程指标
+func NewMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// RegisterCollectors 注册collector，同一个collector已注册过时忽略，可重复调用；
+// 描述相同的另一个collector返回AlreadyRegisteredError
+func RegisterCollectors(registerer prometheus.Registerer, cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := registerer.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) && sameCollector(are.ExistingCollector, c) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sameCollector 是否为同一个collector，不可比较的类型视为不同
+func sameCollector(a, b prometheus.Collector) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// RegisterMetrics 注册srvMetrics及框架内置的限流、并发、优先级、网关和数据库指标。
+// 除srvMetrics外这些指标是包级变量，同一进程中的多个App注册到各自的registry时共享同一组计数，
+// 每个App的/metrics暴露的是整个进程的数据
+func RegisterMetrics(registerer prometheus.Registerer, srvMetrics *grpcprom.ServerMetrics) error {
+	return RegisterCollectors(registerer, srvMetrics, PanicCounterMetrics,
+		ConcurrencyLimitGauge, ConcurrencyInflightGauge, ConcurrencyShedCounter,
+		PriorityRequestCounter, PriorityShedCounter,
+		HTTPRequestCounter, HTTPRequestDuration, HTTPResponseSize, HTTPInflightGauge,
+		GormQueryDuration, GormErrorCounter, GormRowsAffectedCounter, GormDBStatsCollector)
+}
+
+// InitMetrics 在默认registry中注册框架的指标
+//
+// Deprecated: use RegisterMetrics with an App-scoped registry, InitMetrics logs instead of returning the error.
 func InitMetrics() {
-	prometheus.MustRegister(SrvMetrics, PanicCounterMetrics)
+	if err := RegisterMetrics(prometheus.DefaultRegisterer, SrvMetrics); err != nil {
+		logrus.Errorf("register metrics failed: %v", err)
+	}
 }
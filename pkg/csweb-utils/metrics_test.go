@@ -0,0 +1,43 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	srvMetrics := NewServerMetrics([]float64{0.05, 0.5})
+	for i := 0; i < 2; i++ {
+		// 每个registry可重复注册，不同的registry互不影响
+		registry := NewMetricsRegistry()
+		assert.Nil(t, RegisterMetrics(registry, srvMetrics))
+		assert.Nil(t, RegisterMetrics(registry, srvMetrics))
+		families, err := registry.Gather()
+		assert.Nil(t, err)
+		names := make([]string, 0)
+		for _, family := range families {
+			names = append(names, family.GetName())
+		}
+		assert.Contains(t, names, "go_goroutines")
+		assert.Contains(t, names, "grpc_req_panics_recovered_total")
+	}
+
+	// 同名但不同的collector返回错误而不是panic
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, RegisterCollectors(registry, prometheus.NewCounter(prometheus.CounterOpts{Name: "orders_total", Help: "a"})))
+	assert.NotNil(t, RegisterCollectors(registry, prometheus.NewGauge(prometheus.GaugeOpts{Name: "orders_total", Help: "b"})))
+	// 描述完全相同的另一个collector也返回错误，否则它的数据不会被暴露
+	opts := prometheus.CounterOpts{Name: "payments_total", Help: "payments"}
+	payments := prometheus.NewCounter(opts)
+	assert.Nil(t, RegisterCollectors(registry, payments))
+	assert.Nil(t, RegisterCollectors(registry, payments))
+	err := RegisterCollectors(registry, prometheus.NewCounter(opts))
+	var are prometheus.AlreadyRegisteredError
+	assert.ErrorAs(t, err, &are)
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var ClientCertInvalid = status.Error(codes.Unauthenticated, "unknown client certificate")
+
+// CertPrincipalMapper 将客户端证书映射为调用方身份，无法识别时返回false
+type CertPrincipalMapper func(cert *x509.Certificate) (*Principal, bool)
+
+// CertIdentities 证书的身份标识，依次为URI SAN(如spiffe://)、DNS SAN、CN
+func CertIdentities(cert *x509.Certificate) []string {
+	identities := make([]string, 0, len(cert.URIs)+len(cert.DNSNames)+1)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	if len(cert.Subject.CommonName) > 0 {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return identities
+}
+
+// StaticCertMapper 按证书身份标识映射到角色，key为SAN或CN，value为角色列表
+func StaticCertMapper(identities map[string][]string) CertPrincipalMapper {
+	return func(cert *x509.Certificate) (*Principal, bool) {
+		for _, id := range CertIdentities(cert) {
+			roles, ok := identities[id]
+			if !ok {
+				continue
+			}
+			return &Principal{
+				Kind:  CredentialMTLS,
+				Id:    id,
+				Name:  id,
+				Roles: roles,
+			}, true
+		}
+		return nil, false
+	}
+}
+
+// MTLSAuthenticator 通过grpc peer中已校验的客户端证书认证服务调用方
+type MTLSAuthenticator struct {
+	mapper CertPrincipalMapper
+}
+
+func NewMTLSAuthenticator(mapper CertPrincipalMapper) *MTLSAuthenticator {
+	return &MTLSAuthenticator{mapper: mapper}
+}
+
+func (that *MTLSAuthenticator) Kind() string {
+	return CredentialMTLS
+}
+
+func (that *MTLSAuthenticator) Authenticate(ctx context.Context) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return ctx, ErrNoCredential
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx, ErrNoCredential
+	}
+	// 只信任经过CA校验的证书链
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return ctx, ErrNoCredential
+	}
+	principal, ok := that.mapper(chains[0][0])
+	if !ok {
+		return ctx, ClientCertInvalid
+	}
+	return SetPrincipalWithContext(ctx, principal), nil
+}
+
+// NewServerTLSConfig 创建grpc服务端tls配置，clientCAFile非空时校验客户端证书，
+// 未携带证书的请求仍可使用其他凭证认证
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(clientCAFile) > 0 {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return conf, nil
+}
+
+// NewClientTLSConfig 创建grpc客户端tls配置，certFile非空时作为客户端证书，
+// caFile为签发服务端证书的CA，为空时使用系统CA
+func NewClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if len(caFile) > 0 {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	return conf, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	buf, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestMTLSAuthenticator(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://csweb/order")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "order"}, URIs: []*url.URL{spiffe}}
+	authenticator := NewMTLSAuthenticator(StaticCertMapper(map[string][]string{
+		"spiffe://csweb/order": {"service"},
+	}))
+	withPeer := func(chains [][]*x509.Certificate) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: chains}},
+		})
+	}
+
+	ctx, err := authenticator.Authenticate(withPeer([][]*x509.Certificate{{cert}}))
+	assert.Nil(t, err)
+	principal, ok := PrincipalFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, CredentialMTLS, principal.Kind)
+	assert.Equal(t, []string{"service"}, principal.Roles)
+
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}
+	_, err = authenticator.Authenticate(withPeer([][]*x509.Certificate{{unknown}}))
+	assert.ErrorIs(t, err, ClientCertInvalid)
+
+	// 未经校验的证书或非tls连接视为未携带凭证
+	_, err = authenticator.Authenticate(withPeer(nil))
+	assert.ErrorIs(t, err, ErrNoCredential)
+	_, err = authenticator.Authenticate(context.Background())
+	assert.ErrorIs(t, err, ErrNoCredential)
+}
+
+func TestNewClientTLSConfig(t *testing.T) {
+	// 未指定客户端证书时不携带证书，使用系统CA校验服务端
+	conf, err := NewClientTLSConfig("", "", "")
+	assert.Nil(t, err)
+	assert.Empty(t, conf.Certificates)
+	assert.Nil(t, conf.RootCAs)
+
+	_, err = NewClientTLSConfig("missing.pem", "missing.key", "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,320 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"golang.org/x/oauth2"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// OIDCConfig OIDC授权码登录(PKCE)配置
+type OIDCConfig struct {
+	Issuer       string // OIDC provider地址，用于discovery
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // 回调地址，其path即为回调handler的路径
+	Scopes       []string // 默认 openid profile email
+
+	LoginPath          string // 默认 /auth/login
+	LogoutPath         string // 默认 /auth/logout
+	PostLogoutRedirect string // 登出后跳转地址，默认 /
+
+	CookieName     string // 默认 token，与CookieToAuth一致
+	CookieDomain   string
+	CookieSameSite http.SameSite // 默认 Lax，IdP回调属于跨站跳转，Strict会导致登录后首个请求不带cookie
+	InsecureCookie bool          // 不设置Secure，仅用于本地http调试
+
+	// ClaimsMapper 将id token映射为csweb的claims，默认sub->UID, preferred_username/email->Username, name->NickName
+	ClaimsMapper func(idToken *oidc.IDToken) (CustomClaims, error)
+}
+
+// OIDCHandler 网关上的OIDC登录、回调、登出handler，登录成功后签发csweb的jwt并写入cookie
+type OIDCHandler struct {
+	conf         OIDCConfig
+	jwt          *JWT
+	oauth2       *oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+	endSession   string
+	callbackPath string
+}
+
+func NewOIDCHandler(ctx context.Context, conf OIDCConfig, j *JWT) (*OIDCHandler, error) {
+	provider, err := oidc.NewProvider(ctx, conf.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := url.Parse(conf.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if len(conf.LoginPath) == 0 {
+		conf.LoginPath = "/auth/login"
+	}
+	if len(conf.LogoutPath) == 0 {
+		conf.LogoutPath = "/auth/logout"
+	}
+	if len(conf.PostLogoutRedirect) == 0 {
+		conf.PostLogoutRedirect = "/"
+	}
+	if len(conf.CookieName) == 0 {
+		conf.CookieName = "token"
+	}
+	if conf.CookieSameSite == 0 {
+		conf.CookieSameSite = http.SameSiteLaxMode
+	}
+	if conf.ClaimsMapper == nil {
+		conf.ClaimsMapper = defaultOIDCClaimsMapper
+	}
+	var meta struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&meta); err != nil {
+		return nil, err
+	}
+	return &OIDCHandler{
+		conf: conf,
+		jwt:  j,
+		oauth2: &oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       conf.Scopes,
+		},
+		verifier:     provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		endSession:   meta.EndSessionEndpoint,
+		callbackPath: redirect.Path,
+	}, nil
+}
+
+// Register 将登录、回调、登出handler注册到网关，以各自的路径作为指标和span的路由
+func (that *OIDCHandler) Register(mux *runtime.ServeMux) error {
+	handlers := []struct {
+		method string
+		path   string
+		h      http.HandlerFunc
+	}{
+		{http.MethodGet, that.conf.LoginPath, that.Login},
+		{http.MethodGet, that.callbackPath, that.Callback},
+		{http.MethodGet, that.conf.LogoutPath, that.Logout},
+		{http.MethodPost, that.conf.LogoutPath, that.Logout},
+	}
+	for _, item := range handlers {
+		h := item.h
+		if err := HandlePath(mux, item.method, item.path, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			h(w, r)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oidcState 登录流程中保存在cookie里的临时状态
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Redirect string `json:"r"`
+}
+
+// Login 生成state/nonce/PKCE verifier，跳转到OIDC provider的授权页面，?redirect= 指定登录后跳转的站内路径
+func (that *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	st := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: safeRedirect(r.URL.Query().Get("redirect")),
+	}
+	value, err := that.encodeState(st)
+	if err != nil {
+		http.Error(w, "failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     that.callbackPath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   !that.conf.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	authURL := that.oauth2.AuthCodeURL(st.State, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// Callback 校验state，使用授权码和PKCE verifier换取token，校验id token后签发csweb的jwt写入cookie
+func (that *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	logger := LogWithContext(r.Context())
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "login state not found", http.StatusBadRequest)
+		return
+	}
+	st, err := that.decodeState(cookie.Value)
+	if err != nil || st.State != r.URL.Query().Get("state") {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	// 清理state cookie
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: that.callbackPath, MaxAge: -1})
+	if e := r.URL.Query().Get("error"); len(e) > 0 {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	oauth2Token, err := that.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		logger.Errorf("oidc exchange code failed, err: %v", err)
+		http.Error(w, "failed to exchange code", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "id_token not found", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := that.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != st.Nonce {
+		logger.Errorf("oidc verify id token failed, err: %v", err)
+		http.Error(w, "invalid id_token", http.StatusUnauthorized)
+		return
+	}
+	claims, err := that.conf.ClaimsMapper(idToken)
+	if err != nil {
+		logger.Errorf("oidc map claims failed, err: %v", err)
+		http.Error(w, "failed to map claims", http.StatusForbidden)
+		return
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(OneDayTimestamp * time.Second))
+	}
+	token, err := that.jwt.CreateToken(claims)
+	if err != nil {
+		logger.Errorf("create token failed, err: %v", err)
+		http.Error(w, "failed to create token", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     that.conf.CookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   that.conf.CookieDomain,
+		Expires:  claims.ExpiresAt.Time,
+		HttpOnly: true,
+		Secure:   !that.conf.InsecureCookie,
+		SameSite: that.conf.CookieSameSite,
+	})
+	http.Redirect(w, r, st.Redirect, http.StatusFound)
+}
+
+// Logout 清理token cookie，provider支持end_session_endpoint时跳转到provider登出
+func (that *OIDCHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     that.conf.CookieName,
+		Path:     "/",
+		Domain:   that.conf.CookieDomain,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   !that.conf.InsecureCookie,
+		SameSite: that.conf.CookieSameSite,
+	})
+	redirect := that.conf.PostLogoutRedirect
+	if len(that.endSession) > 0 {
+		if u, err := url.Parse(that.endSession); err == nil {
+			q := u.Query()
+			q.Set("client_id", that.conf.ClientID)
+			q.Set("post_logout_redirect_uri", that.conf.PostLogoutRedirect)
+			u.RawQuery = q.Encode()
+			redirect = u.String()
+		}
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// encodeState 使用jwt签名密钥对state做HMAC，防止被篡改
+func (that *OIDCHandler) encodeState(st oidcState) (string, error) {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	return payload + "." + that.sign(payload), nil
+}
+
+func (that *OIDCHandler) decodeState(value string) (*oidcState, error) {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(that.sign(payload))) {
+		return nil, errors.New("invalid state signature")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	st := &oidcState{}
+	if err := json.Unmarshal(buf, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (that *OIDCHandler) sign(payload string) string {
+	mac := hmac.New(sha256.New, that.jwt.SigningKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func defaultOIDCClaimsMapper(idToken *oidc.IDToken) (CustomClaims, error) {
+	var c struct {
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		Name              string `json:"name"`
+	}
+	if err := idToken.Claims(&c); err != nil {
+		return CustomClaims{}, err
+	}
+	username := c.PreferredUsername
+	if len(username) == 0 {
+		username = c.Email
+	}
+	return CustomClaims{
+		UID:      idToken.Subject,
+		Username: username,
+		NickName: c.Name,
+	}, nil
+}
+
+// safeRedirect 只允许站内相对路径，防止开放重定向
+func safeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+func randomString() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeOIDCProvider 本地的OIDC provider，校验PKCE并签发RS256的id token
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	p := &fakeOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"end_session_endpoint":                  p.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, "S256", q.Get("code_challenge_method"))
+		p.challenge = q.Get("code_challenge")
+		p.nonce = q.Get("nonce")
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"fake-code"}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "fake-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.URL,
+			"aud":                "csweb",
+			"sub":                "10001",
+			"nonce":              p.nonce,
+			"preferred_username": "stone",
+			"name":               "stonejianbu",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Hour).Unix(),
+		})
+		idToken.Header["kid"] = "test"
+		raw, _ := idToken.SignedString(p.key)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "fake-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     raw,
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+			}},
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func TestOIDCHandler_LoginFlow(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	defer provider.Close()
+	mux := runtime.NewServeMux()
+	gateway := httptest.NewServer(mux)
+	defer gateway.Close()
+
+	jwtObj := NewJWT("hello")
+	handler, err := NewOIDCHandler(context.Background(), OIDCConfig{
+		Issuer:         provider.URL,
+		ClientID:       "csweb",
+		ClientSecret:   "secret",
+		RedirectURL:    gateway.URL + "/auth/callback",
+		InsecureCookie: true,
+	}, jwtObj)
+	assert.Nil(t, err)
+	assert.Nil(t, handler.Register(mux))
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(gateway.URL + "/auth/login?redirect=/orders")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "/orders", resp.Request.URL.Path)
+
+	gatewayURL, _ := url.Parse(gateway.URL)
+	var token *http.Cookie
+	for _, c := range jar.Cookies(gatewayURL) {
+		if c.Name == "token" {
+			token = c
+		}
+	}
+	assert.NotNil(t, token)
+	claims, err := jwtObj.ParseToken(token.Value)
+	assert.Nil(t, err)
+	assert.Equal(t, "10001", claims.UID)
+	assert.Equal(t, "stone", claims.Username)
+	assert.Equal(t, "stonejianbu", claims.NickName)
+
+	// cookie转换为Authorization
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "zh"})
+	req.AddCookie(token)
+	md := CookieToAuth("token")(context.Background(), req)
+	assert.Equal(t, metadata.Pairs("Authorization", "bearer "+token.Value), md)
+
+	// 登出后cookie被清理
+	resp, err = client.Get(gateway.URL + "/auth/logout")
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "/logout", resp.Request.URL.Path)
+	for _, c := range jar.Cookies(gatewayURL) {
+		assert.NotEqual(t, "token", c.Name)
+	}
+}
+
+func TestOIDCHandler_CallbackRejectsForgedState(t *testing.T) {
+	provider := newFakeOIDCProvider(t)
+	defer provider.Close()
+	handler, err := NewOIDCHandler(context.Background(), OIDCConfig{
+		Issuer:      provider.URL,
+		ClientID:    "csweb",
+		RedirectURL: "http://localhost/auth/callback",
+	}, NewJWT("hello"))
+	assert.Nil(t, err)
+
+	forged, err := NewJWT("other").CreateToken(CustomClaims{})
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/auth/callback?code=fake-code&state=s", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: forged})
+	w := httptest.NewRecorder()
+	handler.Callback(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, "/", safeRedirect("//evil.com"))
+	assert.Equal(t, "/", safeRedirect("https://evil.com"))
+	assert.Equal(t, "/orders?id=1", safeRedirect("/orders?id=1"))
+}
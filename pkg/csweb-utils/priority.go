@@ -0,0 +1,232 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+type Priority = csweb.Priority
+
+const (
+	PriorityCritical = csweb.Priority_PRIORITY_CRITICAL
+	PriorityHigh     = csweb.Priority_PRIORITY_HIGH
+	PriorityNormal   = csweb.Priority_PRIORITY_NORMAL
+	PriorityLow      = csweb.Priority_PRIORITY_LOW
+)
+
+// PriorityHeader 默认的优先级header/metadata
+const PriorityHeader = "x-priority"
+
+// priorityShares 各优先级可使用的容量比例，过载时低优先级的请求先被拒绝，为高优先级保留余量
+var priorityShares = map[Priority]float64{
+	PriorityCritical: 1,
+	PriorityHigh:     0.9,
+	PriorityNormal:   0.8,
+	PriorityLow:      0.5,
+}
+
+// PriorityName 优先级的简短名称，如 critical/high/normal/low
+func PriorityName(p Priority) string {
+	return strings.ToLower(strings.TrimPrefix(p.String(), "PRIORITY_"))
+}
+
+// ParsePriority 解析优先级名称(critical/high/normal/low，不区分大小写)或枚举值
+func ParsePriority(s string) (Priority, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if v, ok := csweb.Priority_value["PRIORITY_"+s]; ok && v != 0 {
+		return Priority(v), true
+	}
+	if v, err := strconv.Atoi(s); err == nil {
+		if _, ok := csweb.Priority_name[int32(v)]; ok && v != 0 {
+			return Priority(v), true
+		}
+	}
+	return 0, false
+}
+
+// PriorityClassifier 判断请求的优先级，返回false表示无法判断，交由下一个classifier
+type PriorityClassifier func(ctx context.Context, fullMethod string) (Priority, bool)
+
+// PriorityTrust 可以通过header请求任意优先级的调用方，满足任一条件即可信
+type PriorityTrust struct {
+	Peers       []netip.Prefix // 可信的来源地址，如内网网段
+	Credentials []string       // 可信的凭证类型，如 CredentialMTLS / CredentialAPIKey，需在鉴权之后
+	Roles       []string       // 可信的角色，需在鉴权之后
+}
+
+func (that PriorityTrust) trusted(ctx context.Context) bool {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		if slices.Contains(that.Credentials, principal.Kind) {
+			return true
+		}
+		for _, role := range principal.Roles {
+			if slices.Contains(that.Roles, role) {
+				return true
+			}
+		}
+	}
+	return isTrustedPeer(ctx, that.Peers)
+}
+
+// PriorityFromHeader 从metadata(网关请求为对应的header)读取优先级。调用方可以自行设置该值，
+// 因此只有trust中的调用方可以请求任意优先级，其他调用方最高只能请求normal，更高的值被忽略，
+// 避免任意调用方(包括普通的jwt用户)将自己提升为critical
+func PriorityFromHeader(name string, trust PriorityTrust) PriorityClassifier {
+	return func(ctx context.Context, _ string) (Priority, bool) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 {
+			return 0, false
+		}
+		p, ok := ParsePriority(values[0])
+		if !ok || (p < PriorityNormal && !trust.trusted(ctx)) {
+			return 0, false
+		}
+		return p, true
+	}
+}
+
+func isTrustedPeer(ctx context.Context, trustedPeers []netip.Prefix) bool {
+	if len(trustedPeers) == 0 {
+		return false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return false
+	}
+	return isTrustedProxy(host, trustedPeers)
+}
+
+// PriorityFromClaim 从jwt claims的自定义字段key读取优先级，如 {"priority": "high"}
+func PriorityFromClaim(key string) PriorityClassifier {
+	return func(ctx context.Context, _ string) (Priority, bool) {
+		custom, ok := CustomFromContext[map[string]any](ctx)
+		if !ok {
+			return 0, false
+		}
+		switch v := custom[key].(type) {
+		case string:
+			return ParsePriority(v)
+		case float64:
+			return ParsePriority(strconv.Itoa(int(v)))
+		}
+		return 0, false
+	}
+}
+
+// PriorityFromMethodOption 读取方法的proto注解 (csweb.priority)
+func PriorityFromMethodOption() PriorityClassifier {
+	var cache sync.Map
+	return func(_ context.Context, fullMethod string) (Priority, bool) {
+		if v, ok := cache.Load(fullMethod); ok {
+			p := v.(Priority)
+			return p, p != 0
+		}
+		p := methodPriority(fullMethod)
+		cache.Store(fullMethod, p)
+		return p, p != 0
+	}
+}
+
+func methodPriority(fullMethod string) Priority {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return 0
+	}
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return 0
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return 0
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil || !proto.HasExtension(md.Options(), csweb.E_Priority) {
+		return 0
+	}
+	return proto.GetExtension(md.Options(), csweb.E_Priority).(Priority)
+}
+
+type priorityKey struct{}
+
+func SetPriorityWithContext(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext 获取ctx中的请求优先级，未启用优先级时返回false
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	return p, ok
+}
+
+// admissionShare 请求可使用的容量比例，未启用优先级时可使用全部容量
+func admissionShare(ctx context.Context) float64 {
+	p, ok := PriorityFromContext(ctx)
+	if !ok {
+		return 1
+	}
+	if share, ok := priorityShares[p]; ok {
+		return share
+	}
+	return 1
+}
+
+var (
+	PriorityRequestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_priority_requests_total",
+		Help: "Total number of gRPC requests by priority class.",
+	}, []string{"priority"})
+	PriorityShedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_priority_shed_total",
+		Help: "Total number of gRPC requests shed by priority class and reason.",
+	}, []string{"priority", "reason"})
+)
+
+// recordShed 记录被限流/并发限制拒绝的请求
+func recordShed(ctx context.Context, reason string) {
+	if p, ok := PriorityFromContext(ctx); ok {
+		PriorityShedCounter.WithLabelValues(PriorityName(p), reason).Inc()
+	}
+}
+
+// WithPriority return a new unary server interceptor that classifies requests into priority classes,
+// 依次使用classifiers判断，均无法判断时健康检查为critical，其他为normal；
+// 之后的并发限制和限流规则按优先级为高优先级的请求保留容量
+func WithPriority(classifiers ...PriorityClassifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		p := PriorityNormal
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			p = PriorityCritical
+		}
+		for _, classify := range classifiers {
+			if v, ok := classify(ctx, info.FullMethod); ok {
+				p = v
+				break
+			}
+		}
+		PriorityRequestCounter.WithLabelValues(PriorityName(p)).Inc()
+		return handler(SetPriorityWithContext(ctx, p), req)
+	}
+}
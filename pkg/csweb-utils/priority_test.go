@@ -0,0 +1,116 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestParsePriority(t *testing.T) {
+	p, ok := ParsePriority("High")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityHigh, p)
+	p, ok = ParsePriority("4")
+	assert.True(t, ok)
+	assert.Equal(t, PriorityLow, p)
+	_, ok = ParsePriority("unspecified")
+	assert.False(t, ok)
+	_, ok = ParsePriority("urgent")
+	assert.False(t, ok)
+	assert.Equal(t, "critical", PriorityName(PriorityCritical))
+}
+
+func TestWithPriority(t *testing.T) {
+	claims := &CustomClaims{}
+	assert.Nil(t, claims.SetCustom(map[string]any{"priority": "high"}))
+	interceptor := WithPriority(PriorityFromHeader(PriorityHeader, PriorityTrust{}), PriorityFromClaim("priority"))
+	classify := func(ctx context.Context, method string) Priority {
+		resp, _ := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			p, ok := PriorityFromContext(ctx)
+			assert.True(t, ok)
+			return p, nil
+		})
+		return resp.(Priority)
+	}
+	assert.Equal(t, PriorityNormal, classify(context.Background(), "/order.Order/Get"))
+	assert.Equal(t, PriorityCritical, classify(context.Background(), "/grpc.health.v1.Health/Check"))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(PriorityHeader, "low"))
+	assert.Equal(t, PriorityLow, classify(SetPrincipalWithContext(ctx, &Principal{Kind: CredentialAPIKey, Id: "batch"}), "/order.Order/Export"))
+	ctx = context.WithValue(context.Background(), ClaimsKey, claims)
+	assert.Equal(t, PriorityHigh, classify(ctx, "/order.Order/Get"))
+}
+
+func TestPriorityFromHeader_Trust(t *testing.T) {
+	critical := metadata.NewIncomingContext(context.Background(), metadata.Pairs(PriorityHeader, "critical"))
+	internal := peer.NewContext(critical, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	user := SetPrincipalWithContext(critical, &Principal{Kind: CredentialJwt, Id: "1", Roles: []string{"user"}})
+	trust := PriorityTrust{
+		Peers:       []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Credentials: []string{CredentialMTLS},
+		Roles:       []string{"ops"},
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"anonymous", critical, false},
+		{"untrusted peer", peer.NewContext(critical, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 5000}}), false},
+		{"trusted peer", internal, true},
+		{"jwt principal", user, false},
+		{"trusted credential", SetPrincipalWithContext(critical, &Principal{Kind: CredentialMTLS, Id: "billing"}), true},
+		{"trusted role", SetPrincipalWithContext(critical, &Principal{Kind: CredentialJwt, Id: "2", Roles: []string{"ops"}}), true},
+	}
+	for _, tt := range tests {
+		p, ok := PriorityFromHeader(PriorityHeader, trust)(tt.ctx, "/order.Order/Get")
+		assert.Equal(t, tt.want, ok, tt.name)
+		if tt.want {
+			assert.Equal(t, PriorityCritical, p, tt.name)
+		}
+	}
+
+	// 不可信的调用方最高只能请求normal
+	for _, name := range []string{"normal", "low"} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(PriorityHeader, name))
+		p, ok := PriorityFromHeader(PriorityHeader, trust)(ctx, "/order.Order/Get")
+		assert.True(t, ok)
+		assert.Equal(t, name, PriorityName(p))
+	}
+}
+
+func TestPriorityAdmission(t *testing.T) {
+	limiter := NewConcurrencyLimiter(NewFixedLimit(10), newFakeClock())
+	low := admissionShare(SetPriorityWithContext(context.Background(), PriorityLow))
+	critical := admissionShare(SetPriorityWithContext(context.Background(), PriorityCritical))
+	for i := 0; i < 5; i++ {
+		_, ok := limiter.AcquireShare(low)
+		assert.True(t, ok)
+	}
+	// 低优先级只能使用一半的并发名额，剩余名额保留给高优先级
+	_, ok := limiter.AcquireShare(low)
+	assert.False(t, ok)
+	for i := 0; i < 5; i++ {
+		_, ok = limiter.AcquireShare(critical)
+		assert.True(t, ok)
+	}
+	_, ok = limiter.AcquireShare(critical)
+	assert.False(t, ok)
+
+	bucket := NewTokenBucketWithClock(1, 10, newFakeClock())
+	for i := 0; i < 5; i++ {
+		assert.True(t, bucket.TakeShare(1, low).Allowed)
+	}
+	assert.False(t, bucket.TakeShare(1, low).Allowed)
+	assert.True(t, bucket.TakeShare(1, critical).Allowed)
+	assert.Equal(t, 1.0, admissionShare(context.Background()))
+}
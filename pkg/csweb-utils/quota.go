@@ -0,0 +1,335 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaPeriod 配额的统计周期，窗口在周期边界(按QuotaRule.Location)滚动
+type QuotaPeriod int
+
+const (
+	QuotaDaily QuotaPeriod = iota + 1
+	QuotaMonthly
+)
+
+// window 返回now所在窗口的起止时间
+func (p QuotaPeriod) window(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	switch p {
+	case QuotaMonthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// KeyByTenant 按jwt claims中的TenantId统计，未携带租户的请求不适用
+func KeyByTenant() RateLimitKeyFunc {
+	return func(ctx context.Context, _ string) (string, bool) {
+		return TenantIdFromContext(ctx)
+	}
+}
+
+// QuotaRule 配额规则，每个key在每个窗口内最多调用Limit次，如每个租户每天10万次
+type QuotaRule struct {
+	Name     string           // 规则名称
+	Methods  []string         // 适用的grpc方法，支持path.Match通配符，为空时适用所有方法
+	Key      RateLimitKeyFunc // 配额key，如 KeyByTenant / KeyByPrincipal，为空时按租户统计
+	Limit    int64            // 每个窗口允许的调用次数
+	Period   QuotaPeriod      // 统计周期
+	Location *time.Location   // 窗口边界所在的时区，默认UTC
+}
+
+func (that QuotaRule) match(fullMethod string) bool {
+	return RateLimitRule{Methods: that.Methods}.match(fullMethod)
+}
+
+func (that QuotaRule) window(now time.Time) (time.Time, time.Time) {
+	return that.Period.window(now, that.Location)
+}
+
+// QuotaStore 配额用量的持久化存储，多副本共享同一存储时配额在副本间共享
+type QuotaStore interface {
+	// Consume 窗口内的用量增加n，增加后超过limit时不增加并返回false；
+	// n为负数时退还用量，用量不会小于0
+	Consume(ctx context.Context, rule, key string, windowStart time.Time, n, limit int64) (used int64, ok bool, err error)
+	// Usage 窗口内的用量
+	Usage(ctx context.Context, rule, key string, windowStart time.Time) (int64, error)
+	// Reset 清零窗口内的用量
+	Reset(ctx context.Context, rule, key string, windowStart time.Time) error
+}
+
+// MemoryQuotaStore 进程内的配额存储，仅用于单副本或测试，重启后用量丢失
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[string]int64)}
+}
+
+func (that *MemoryQuotaStore) id(rule, key string, windowStart time.Time) string {
+	return fmt.Sprintf("%s|%s|%d", rule, key, windowStart.Unix())
+}
+
+func (that *MemoryQuotaStore) Consume(_ context.Context, rule, key string, windowStart time.Time, n, limit int64) (int64, bool, error) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	id := that.id(rule, key, windowStart)
+	used := that.usage[id]
+	if used+n > limit {
+		return used, false, nil
+	}
+	that.usage[id] = max(used+n, 0)
+	return that.usage[id], true, nil
+}
+
+func (that *MemoryQuotaStore) Usage(_ context.Context, rule, key string, windowStart time.Time) (int64, error) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return that.usage[that.id(rule, key, windowStart)], nil
+}
+
+func (that *MemoryQuotaStore) Reset(_ context.Context, rule, key string, windowStart time.Time) error {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	delete(that.usage, that.id(rule, key, windowStart))
+	return nil
+}
+
+// QuotaCounter 每个规则、key、窗口一行用量记录，历史窗口的记录保留用于对账
+type QuotaCounter struct {
+	ID          uint      `gorm:"primarykey"`
+	Rule        string    `gorm:"column:rule_name;size:64;uniqueIndex:idx_quota_window"`
+	Key         string    `gorm:"column:quota_key;size:128;uniqueIndex:idx_quota_window"`
+	WindowStart time.Time `gorm:"uniqueIndex:idx_quota_window"`
+	Used        int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// GormQuotaStore 基于数据库的配额存储，通过条件更新保证并发下不超额
+type GormQuotaStore struct {
+	db *gorm.DB
+}
+
+func NewGormQuotaStore(db *gorm.DB) *GormQuotaStore {
+	return &GormQuotaStore{db: db}
+}
+
+// AutoMigrate 创建配额用量表
+func (that *GormQuotaStore) AutoMigrate() error {
+	return that.db.AutoMigrate(&QuotaCounter{})
+}
+
+func (that *GormQuotaStore) Consume(ctx context.Context, rule, key string, windowStart time.Time, n, limit int64) (int64, bool, error) {
+	db := CurrentDB(ctx, that.db).WithContext(ctx)
+	// 窗口的第一次调用创建记录，已存在时忽略
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&QuotaCounter{Rule: rule, Key: key, WindowStart: windowStart}).Error
+	if err != nil {
+		return 0, false, err
+	}
+	ret := db.Model(&QuotaCounter{}).
+		Where("rule_name = ? AND quota_key = ? AND window_start = ? AND used + ? <= ?", rule, key, windowStart, n, limit).
+		UpdateColumn("used", gorm.Expr("CASE WHEN used + ? < 0 THEN 0 ELSE used + ? END", n, n))
+	if ret.Error != nil {
+		return 0, false, ret.Error
+	}
+	used, err := that.Usage(ctx, rule, key, windowStart)
+	return used, ret.RowsAffected > 0, err
+}
+
+func (that *GormQuotaStore) Usage(ctx context.Context, rule, key string, windowStart time.Time) (int64, error) {
+	var counters []QuotaCounter
+	err := CurrentDB(ctx, that.db).WithContext(ctx).
+		Where("rule_name = ? AND quota_key = ? AND window_start = ?", rule, key, windowStart).
+		Limit(1).Find(&counters).Error
+	if err != nil || len(counters) == 0 {
+		return 0, err
+	}
+	return counters[0].Used, nil
+}
+
+func (that *GormQuotaStore) Reset(ctx context.Context, rule, key string, windowStart time.Time) error {
+	return CurrentDB(ctx, that.db).WithContext(ctx).Model(&QuotaCounter{}).
+		Where("rule_name = ? AND quota_key = ? AND window_start = ?", rule, key, windowStart).
+		UpdateColumn("used", 0).Error
+}
+
+// quotaError 返回携带QuotaFailure和RetryInfo(到下一个窗口)的ResourceExhausted错误
+func quotaError(rule QuotaRule, key string, retryAfter time.Duration) error {
+	msg := fmt.Sprintf("quota %s exceeded, please retry after the current window", rule.Name)
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     rule.Name + ":" + key,
+				Description: fmt.Sprintf("limit of %d calls per window exceeded", rule.Limit),
+			}},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
+
+// WithQuota return a new unary server interceptor that enforces quotas persisted in store,
+// 请求需通过所有适用的规则，被某个规则拒绝时退还已计入其他规则的用量；存储不可用时放行，避免配额统计影响服务可用性
+func WithQuota(store QuotaStore, rules ...QuotaRule) grpc.UnaryServerInterceptor {
+	return withQuota(store, SystemClock, rules...)
+}
+
+func withQuota(store QuotaStore, clock Clock, rules ...QuotaRule) grpc.UnaryServerInterceptor {
+	rules = slices.Clone(rules)
+	for i, rule := range rules {
+		if rule.Key == nil {
+			rules[i].Key = KeyByTenant()
+		}
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		now := clock.Now()
+		consumed := make([]quotaConsumption, 0, len(rules))
+		for _, rule := range rules {
+			if !rule.match(info.FullMethod) {
+				continue
+			}
+			key, ok := rule.Key(ctx, info.FullMethod)
+			if !ok {
+				continue
+			}
+			start, end := rule.window(now)
+			_, ok, err := store.Consume(ctx, rule.Name, key, start, 1, rule.Limit)
+			if err != nil {
+				logrus.Warnf("quota store unavailable, skip quota %s, err: %v", rule.Name, err)
+				continue
+			}
+			if !ok {
+				refundQuota(ctx, store, consumed)
+				return nil, quotaError(rule, key, end.Sub(now))
+			}
+			consumed = append(consumed, quotaConsumption{rule: rule, key: key, windowStart: start})
+		}
+		return handler(ctx, req)
+	}
+}
+
+// quotaConsumption 请求已计入的一次用量
+type quotaConsumption struct {
+	rule        QuotaRule
+	key         string
+	windowStart time.Time
+}
+
+// refundQuota 退还请求已计入的用量，避免被后续规则拒绝的请求占用前面规则的配额
+func refundQuota(ctx context.Context, store QuotaStore, consumed []quotaConsumption) {
+	for _, c := range consumed {
+		if _, _, err := store.Consume(ctx, c.rule.Name, c.key, c.windowStart, -1, c.rule.Limit); err != nil {
+			logrus.Warnf("refund quota %s failed, err: %v", c.rule.Name, err)
+		}
+	}
+}
+
+// QuotaAdmin 配额管理服务，查询和重置key在各规则当前窗口的用量
+type QuotaAdmin struct {
+	csweb.UnimplementedQuotaAdminServer
+	store QuotaStore
+	rules []QuotaRule
+	clock Clock
+}
+
+func NewQuotaAdmin(store QuotaStore, rules ...QuotaRule) *QuotaAdmin {
+	return &QuotaAdmin{store: store, rules: rules, clock: SystemClock}
+}
+
+// RegisterQuotaAdmin 注册配额管理服务，方法通过 (csweb.auth) 要求admin角色，需同时启用鉴权
+func RegisterQuotaAdmin(s *grpc.Server, store QuotaStore, rules ...QuotaRule) {
+	csweb.RegisterQuotaAdminServer(s, NewQuotaAdmin(store, rules...))
+}
+
+func (that *QuotaAdmin) GetQuotaUsage(ctx context.Context, req *csweb.GetQuotaUsageReq) (*csweb.GetQuotaUsageResp, error) {
+	if len(req.GetKey()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	usages, err := that.usages(ctx, req.GetKey(), "")
+	if err != nil {
+		return nil, err
+	}
+	return &csweb.GetQuotaUsageResp{Usages: usages}, nil
+}
+
+func (that *QuotaAdmin) ResetQuotaUsage(ctx context.Context, req *csweb.ResetQuotaUsageReq) (*csweb.ResetQuotaUsageResp, error) {
+	if len(req.GetKey()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	now := that.clock.Now()
+	found := false
+	for _, rule := range that.rules {
+		if len(req.GetRule()) > 0 && rule.Name != req.GetRule() {
+			continue
+		}
+		found = true
+		start, _ := rule.window(now)
+		if err := that.store.Reset(ctx, rule.Name, req.GetKey(), start); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "reset quota %s failed: %v", rule.Name, err)
+		}
+	}
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "quota rule %s not found", req.GetRule())
+	}
+	usages, err := that.usages(ctx, req.GetKey(), req.GetRule())
+	if err != nil {
+		return nil, err
+	}
+	return &csweb.ResetQuotaUsageResp{Usages: usages}, nil
+}
+
+// usages key在各规则当前窗口的用量，ruleName为空时返回所有规则
+func (that *QuotaAdmin) usages(ctx context.Context, key, ruleName string) ([]*csweb.QuotaUsage, error) {
+	now := that.clock.Now()
+	usages := make([]*csweb.QuotaUsage, 0, len(that.rules))
+	for _, rule := range that.rules {
+		if len(ruleName) > 0 && rule.Name != ruleName {
+			continue
+		}
+		start, end := rule.window(now)
+		used, err := that.store.Usage(ctx, rule.Name, key, start)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "get quota %s usage failed: %v", rule.Name, err)
+		}
+		usages = append(usages, &csweb.QuotaUsage{
+			Rule:        rule.Name,
+			Key:         key,
+			Used:        used,
+			Limit:       rule.Limit,
+			WindowStart: timestamppb.New(start),
+			WindowEnd:   timestamppb.New(end),
+		})
+	}
+	return usages, nil
+}
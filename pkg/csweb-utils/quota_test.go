@@ -0,0 +1,161 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaPeriod_Window(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)
+	start, end := QuotaDaily.window(now, nil)
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC), end)
+	start, end = QuotaMonthly.window(now, nil)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), end)
+	loc := time.FixedZone("CST", 8*3600)
+	start, _ = QuotaDaily.window(time.Date(2024, 3, 15, 20, 0, 0, 0, time.UTC), loc)
+	assert.Equal(t, time.Date(2024, 3, 16, 0, 0, 0, 0, loc), start)
+}
+
+func TestWithQuota(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryQuotaStore()
+	rule := QuotaRule{Name: "tenant-daily", Key: KeyByTenant(), Limit: 2, Period: QuotaDaily}
+	interceptor := withQuota(store, clock, rule)
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Create"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	ctx := context.WithValue(context.Background(), ClaimsKey, &CustomClaims{TenantId: "t1"})
+
+	for i := 0; i < 2; i++ {
+		_, err := interceptor(ctx, nil, info, handler)
+		assert.Nil(t, err)
+	}
+	_, err := interceptor(ctx, nil, info, handler)
+	s := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, s.Code())
+	assert.Len(t, s.Details(), 2)
+	failure, ok := s.Details()[0].(*errdetails.QuotaFailure)
+	assert.True(t, ok)
+	assert.Equal(t, "tenant-daily:t1", failure.GetViolations()[0].GetSubject())
+	// 其他租户和未携带租户的请求不受影响
+	_, err = interceptor(context.WithValue(context.Background(), ClaimsKey, &CustomClaims{TenantId: "t2"}), nil, info, handler)
+	assert.Nil(t, err)
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Nil(t, err)
+
+	// 管理接口查询和重置用量
+	admin := NewQuotaAdmin(store, rule)
+	admin.clock = clock
+	usage, err := admin.GetQuotaUsage(context.Background(), &csweb.GetQuotaUsageReq{Key: "t1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), usage.GetUsages()[0].GetUsed())
+	assert.Equal(t, int64(2), usage.GetUsages()[0].GetLimit())
+	reset, err := admin.ResetQuotaUsage(context.Background(), &csweb.ResetQuotaUsageReq{Key: "t1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), reset.GetUsages()[0].GetUsed())
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+	_, err = admin.ResetQuotaUsage(context.Background(), &csweb.ResetQuotaUsageReq{Key: "t1", Rule: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// 进入下一个窗口后配额恢复
+	clock.Advance(24 * time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err = interceptor(ctx, nil, info, handler)
+		assert.Nil(t, err)
+	}
+}
+
+func TestWithQuota_Refund(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	tenant := QuotaRule{Name: "tenant-daily", Key: KeyByTenant(), Limit: 10, Period: QuotaDaily}
+	export := QuotaRule{Name: "export-daily", Methods: []string{"/order.Order/Export"}, Key: KeyByTenant(), Limit: 1, Period: QuotaDaily}
+	clock := newFakeClock()
+	interceptor := withQuota(store, clock, tenant, export)
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Export"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	ctx := context.WithValue(context.Background(), ClaimsKey, &CustomClaims{TenantId: "t1"})
+
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = interceptor(ctx, nil, info, handler)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	// 被export规则拒绝的请求不占用tenant规则的配额
+	start, _ := tenant.window(clock.Now())
+	used, err := store.Usage(context.Background(), tenant.Name, "t1", start)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), used)
+}
+
+func TestWithQuota_NilKey(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	interceptor := withQuota(store, newFakeClock(), QuotaRule{Name: "daily", Limit: 1, Period: QuotaDaily})
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Create"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	ctx := context.WithValue(context.Background(), ClaimsKey, &CustomClaims{TenantId: "t1"})
+
+	// 未设置Key时按租户统计
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Nil(t, err)
+}
+
+func TestGormQuotaStore(t *testing.T) {
+	store := NewGormQuotaStore(newSqliteDB(t))
+	assert.Nil(t, store.AutoMigrate())
+	ctx := context.Background()
+	start := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+
+	for i := int64(1); i <= 2; i++ {
+		used, ok, err := store.Consume(ctx, "daily", "t1", start, 1, 2)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, i, used)
+	}
+	used, ok, err := store.Consume(ctx, "daily", "t1", start, 1, 2)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, int64(2), used)
+
+	// 不同key和窗口分别统计
+	used, ok, err = store.Consume(ctx, "daily", "t2", start, 1, 2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), used)
+	used, err = store.Usage(ctx, "daily", "t1", start.AddDate(0, 0, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), used)
+
+	// 退还用量
+	used, ok, err = store.Consume(ctx, "daily", "t1", start, -1, 2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), used)
+
+	assert.Nil(t, store.Reset(ctx, "daily", "t1", start))
+	used, err = store.Usage(ctx, "daily", "t1", start)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), used)
+	// 重置后退还的用量不会小于0
+	used, _, err = store.Consume(ctx, "daily", "t1", start, -1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), used)
+}
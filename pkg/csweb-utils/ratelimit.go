@@ -0,0 +1,331 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RateLimitKeyFunc 从请求中提取限流key，返回false表示该规则不适用于此请求
+type RateLimitKeyFunc func(ctx context.Context, fullMethod string) (string, bool)
+
+// KeyByMethod 按grpc方法限流
+func KeyByMethod() RateLimitKeyFunc {
+	return func(_ context.Context, fullMethod string) (string, bool) {
+		return fullMethod, true
+	}
+}
+
+// KeyByUID 按jwt claims中的UID限流，未携带jwt的请求不适用
+func KeyByUID() RateLimitKeyFunc {
+	return func(ctx context.Context, _ string) (string, bool) {
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok || len(claims.UID) == 0 {
+			return "", false
+		}
+		return claims.UID, true
+	}
+}
+
+// KeyByPrincipal 按已认证的调用方(jwt/apikey/mtls)限流
+func KeyByPrincipal() RateLimitKeyFunc {
+	return func(ctx context.Context, _ string) (string, bool) {
+		principal, ok := PrincipalFromContext(ctx)
+		if !ok {
+			return "", false
+		}
+		return principal.Kind + ":" + principal.Id, true
+	}
+}
+
+// KeyByPeerIP 按调用方IP限流，trustedProxies为网关等可信代理的网段(如同进程的网关为 127.0.0.1/32)，
+// 只有来自可信代理的请求才取x-forwarded-for中代理追加的地址，其他请求的x-forwarded-for可被调用方伪造，使用连接地址
+func KeyByPeerIP(trustedProxies ...netip.Prefix) RateLimitKeyFunc {
+	return func(ctx context.Context, _ string) (string, bool) {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return "", false
+		}
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		if !isTrustedProxy(host, trustedProxies) {
+			return host, true
+		}
+		if values := metadata.ValueFromIncomingContext(ctx, "x-forwarded-for"); len(values) > 0 {
+			// 网关会将客户端地址追加到末尾，前面的值可被客户端伪造
+			items := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(items[len(items)-1]); len(ip) > 0 {
+				return ip, true
+			}
+		}
+		return host, true
+	}
+}
+
+func isTrustedProxy(host string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyByHeader 按metadata(网关请求为对应的header)的值限流，未携带时不适用
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(ctx context.Context, _ string) (string, bool) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 || len(values[0]) == 0 {
+			return "", false
+		}
+		return values[0], true
+	}
+}
+
+// RateLimitRule 限流规则，每个key拥有独立的令牌桶
+type RateLimitRule struct {
+	Name    string           // 规则名称
+	Methods []string         // 适用的grpc方法，支持path.Match通配符(如 /order.Order/*)，为空时适用所有方法
+	Key     RateLimitKeyFunc // 限流key，为空时使用KeyByPeerIP()
+	Rate    float64          // 每个key每秒允许的请求数
+	Burst   int              // 每个key允许的突发请求数
+	IdleTTL time.Duration    // key空闲超过该时间后回收其令牌桶，默认取桶回满所需时间与1分钟的较大值
+
+	// Distributed 非空时使用多副本共享的限流器，后端不可用时降级为按Rate/Burst的本地限流
+	Distributed DistributedLimiter
+}
+
+func (that RateLimitRule) match(fullMethod string) bool {
+	if len(that.Methods) == 0 {
+		return true
+	}
+	for _, pattern := range that.Methods {
+		if ok, _ := path.Match(pattern, fullMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyedLimiter 按key区分的令牌桶集合，空闲的桶会被回收以限制内存
+type KeyedLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	idleTTL   time.Duration
+	clock     Clock
+	buckets   map[string]*keyedBucket
+	lastSweep time.Time
+}
+
+type keyedBucket struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+func NewKeyedLimiter(rate float64, burst int, idleTTL time.Duration, clock Clock) *KeyedLimiter {
+	if idleTTL <= 0 {
+		// 空闲超过桶回满所需的时间后，回收与保留的效果一致
+		idleTTL = time.Minute
+		if rate > 0 {
+			if refill := time.Duration(float64(burst) / rate * float64(time.Second)); refill > idleTTL {
+				idleTTL = refill
+			}
+		}
+	}
+	return &KeyedLimiter{
+		rate:      rate,
+		burst:     burst,
+		idleTTL:   idleTTL,
+		clock:     clock,
+		buckets:   make(map[string]*keyedBucket),
+		lastSweep: clock.Now(),
+	}
+}
+
+// Allow key是否获取到令牌
+func (that *KeyedLimiter) Allow(key string) bool {
+	return that.bucket(key).Allow()
+}
+
+// Take key尝试获取一个令牌，并返回剩余配额
+func (that *KeyedLimiter) Take(key string) RateLimitResult {
+	return that.bucket(key).Take()
+}
+
+// TakeShare key尝试获取一个令牌，只能使用桶容量的share比例
+func (that *KeyedLimiter) TakeShare(key string, share float64) RateLimitResult {
+	return that.bucket(key).TakeShare(1, share)
+}
+
+// bucket 获取key对应的令牌桶，不存在时创建
+func (that *KeyedLimiter) bucket(key string) *TokenBucket {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	now := that.clock.Now()
+	that.sweep(now)
+	b, ok := that.buckets[key]
+	if !ok {
+		b = &keyedBucket{bucket: NewTokenBucketWithClock(that.rate, that.burst, that.clock)}
+		that.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.bucket
+}
+
+// sweep 每隔idleTTL回收一次空闲的令牌桶，调用方需持有锁
+func (that *KeyedLimiter) sweep(now time.Time) {
+	if now.Sub(that.lastSweep) < that.idleTTL {
+		return
+	}
+	for key, b := range that.buckets {
+		if now.Sub(b.lastSeen) >= that.idleTTL {
+			delete(that.buckets, key)
+		}
+	}
+	that.lastSweep = now
+}
+
+// Len 当前的令牌桶数量
+func (that *KeyedLimiter) Len() int {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return len(that.buckets)
+}
+
+// WithRateLimitRules return a new unary server interceptor that performs rate limiting by rules,
+// a request must pass every rule that applies to it.
+// 启用WithPriority时本地令牌桶为高优先级的请求保留容量，分布式限流不区分优先级
+func WithRateLimitRules(rules ...RateLimitRule) grpc.UnaryServerInterceptor {
+	limiters := make([]func(ctx context.Context, key string) RateLimitResult, len(rules))
+	rules = slices.Clone(rules)
+	for i, rule := range rules {
+		if rule.Key == nil {
+			rules[i].Key = KeyByPeerIP()
+		}
+		local := NewKeyedLimiter(rule.Rate, rule.Burst, rule.IdleTTL, SystemClock)
+		if rule.Distributed != nil {
+			limiters[i] = NewFallbackLimiter(rule.Distributed, local, 100*time.Millisecond, 5*time.Second).Take
+			continue
+		}
+		limiters[i] = func(ctx context.Context, key string) RateLimitResult {
+			return local.TakeShare(key, admissionShare(ctx))
+		}
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		results := make([]RateLimitResult, 0, len(rules))
+		msg := ""
+		for i, rule := range rules {
+			if !rule.match(info.FullMethod) {
+				continue
+			}
+			key, ok := rule.Key(ctx, info.FullMethod)
+			if !ok {
+				continue
+			}
+			ret := limiters[i](ctx, rule.Name+":"+key)
+			results = append(results, ret)
+			if !ret.Allowed {
+				msg = fmt.Sprintf("ratelimit rejected by rule %s, please retry later", rule.Name)
+				recordShed(ctx, "ratelimit")
+				break
+			}
+		}
+		return serveWithRateLimit(ctx, req, handler, msg, results...)
+	}
+}
+
+const (
+	RateLimitLimitHeader     = "x-ratelimit-limit"
+	RateLimitRemainingHeader = "x-ratelimit-remaining"
+	RateLimitResetHeader     = "x-ratelimit-reset"
+)
+
+// rateLimitState 一次请求经过的各限流器中最严格的结果
+type rateLimitState struct {
+	mu     sync.Mutex
+	result *RateLimitResult
+}
+
+type rateLimitStateKey struct{}
+
+func (that *rateLimitState) record(ret RateLimitResult) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.result == nil || (that.result.Allowed && (!ret.Allowed || ret.Remaining < that.result.Remaining)) {
+		that.result = &ret
+	}
+}
+
+func (that *rateLimitState) header() metadata.MD {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	if that.result == nil {
+		return nil
+	}
+	return metadata.Pairs(
+		RateLimitLimitHeader, strconv.Itoa(that.result.Limit),
+		RateLimitRemainingHeader, strconv.Itoa(that.result.Remaining),
+		RateLimitResetHeader, strconv.Itoa(int(math.Ceil(that.result.ResetAfter.Seconds()))),
+	)
+}
+
+// serveWithRateLimit 根据限流结果决定是否执行handler，被拒绝时返回携带RetryInfo的ResourceExhausted错误，
+// 最外层的限流拦截器负责将最严格的结果写入响应header(x-ratelimit-limit/remaining/reset)
+func serveWithRateLimit(ctx context.Context, req any, handler grpc.UnaryHandler, rejectMsg string, results ...RateLimitResult) (any, error) {
+	state, nested := ctx.Value(rateLimitStateKey{}).(*rateLimitState)
+	if !nested {
+		state = &rateLimitState{}
+		ctx = context.WithValue(ctx, rateLimitStateKey{}, state)
+		defer func() {
+			if md := state.header(); md != nil {
+				_ = grpc.SetHeader(ctx, md)
+			}
+		}()
+	}
+	for _, ret := range results {
+		state.record(ret)
+		if !ret.Allowed {
+			return nil, rateLimitError(rejectMsg, ret)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// rateLimitError 返回携带RetryInfo的ResourceExhausted错误
+func rateLimitError(msg string, ret RateLimitResult) error {
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(ret.RetryAfter),
+	})
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	return st.Err()
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// DistributedLimiter 多副本共享配额的限流器
+type DistributedLimiter interface {
+	// Take key尝试获取一个令牌并返回剩余配额，后端不可用时返回error
+	Take(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+// gcraScript GCRA(generic cell rate algorithm)限流，只保存每个key的理论到达时间(TAT)，
+// 使用redis服务端时间避免各副本时钟不一致
+// KEYS[1]: key, ARGV[1]: 生成一个令牌的间隔(微秒), ARGV[2]: 突发容量
+// 返回 {是否允许, 剩余请求数, 需要等待的微秒数, 距离回满的微秒数}
+var gcraScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+local new_tat = tat + interval
+local allow_at = new_tat - burst * interval
+if allow_at > now then
+  return {0, 0, allow_at - now, tat - now}
+end
+redis.call("SET", KEYS[1], string.format("%d", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now - allow_at) / interval), 0, new_tat - now}
+`)
+
+// RedisLimiter 基于redis lua脚本实现的GCRA分布式限流器
+type RedisLimiter struct {
+	client   redis.Scripter
+	prefix   string
+	interval int64 // 生成一个令牌的间隔(微秒)
+	burst    int
+}
+
+// NewRedisLimiter 创建分布式限流器，rate为所有副本合计每秒允许的请求数，burst为突发容量
+func NewRedisLimiter(client redis.Scripter, prefix string, rate float64, burst int) *RedisLimiter {
+	interval := int64(float64(time.Second/time.Microsecond) / rate)
+	if interval < 1 {
+		interval = 1
+	}
+	return &RedisLimiter{
+		client:   client,
+		prefix:   prefix,
+		interval: interval,
+		burst:    burst,
+	}
+}
+
+func (that *RedisLimiter) Take(ctx context.Context, key string) (RateLimitResult, error) {
+	ret, err := gcraScript.Run(ctx, that.client, []string{that.prefix + key}, that.interval, that.burst).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(ret) != 4 {
+		return RateLimitResult{}, fmt.Errorf("unexpected gcra script result: %v", ret)
+	}
+	return RateLimitResult{
+		Allowed:    ret[0] == 1,
+		Limit:      that.burst,
+		Remaining:  int(ret[1]),
+		RetryAfter: time.Duration(ret[2]) * time.Microsecond,
+		ResetAfter: time.Duration(ret[3]) * time.Microsecond,
+	}, nil
+}
+
+// Allow key是否获取到令牌
+func (that *RedisLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	ret, err := that.Take(ctx, key)
+	return ret.Allowed, err
+}
+
+// FallbackLimiter 优先使用分布式限流器，后端不可用时降级为本地限流器，并在cooldown内不再访问后端
+type FallbackLimiter struct {
+	primary   DistributedLimiter
+	fallback  *KeyedLimiter
+	timeout   time.Duration
+	cooldown  time.Duration
+	clock     Clock
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewFallbackLimiter timeout为访问后端的超时时间，cooldown为后端失败后降级的持续时间
+func NewFallbackLimiter(primary DistributedLimiter, fallback *KeyedLimiter, timeout, cooldown time.Duration) *FallbackLimiter {
+	return &FallbackLimiter{
+		primary:  primary,
+		fallback: fallback,
+		timeout:  timeout,
+		cooldown: cooldown,
+		clock:    SystemClock,
+	}
+}
+
+// Take key尝试获取一个令牌，并返回剩余配额
+func (that *FallbackLimiter) Take(ctx context.Context, key string) RateLimitResult {
+	if that.available() {
+		if that.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, that.timeout)
+			defer cancel()
+		}
+		ret, err := that.primary.Take(ctx, key)
+		if err == nil {
+			return ret
+		}
+		that.markDown(err)
+	}
+	return that.fallback.Take(key)
+}
+
+// Allow key是否获取到令牌
+func (that *FallbackLimiter) Allow(ctx context.Context, key string) bool {
+	return that.Take(ctx, key).Allowed
+}
+
+func (that *FallbackLimiter) available() bool {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	return !that.clock.Now().Before(that.downUntil)
+}
+
+func (that *FallbackLimiter) markDown(err error) {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.downUntil = that.clock.Now().Add(that.cooldown)
+	logrus.Warnf("distributed rate limiter unavailable, fallback to local limiter for %s, err: %v", that.cooldown, err)
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisLimiter_GCRA(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Unix(1700000000, 0)
+	mr.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	// 两个副本共享配额
+	replica1 := NewRedisLimiter(client, "csweb:ratelimit:", 10, 2)
+	replica2 := NewRedisLimiter(client, "csweb:ratelimit:", 10, 2)
+	ctx := context.Background()
+
+	ret, err := replica1.Take(ctx, "user:1")
+	assert.Nil(t, err)
+	assert.True(t, ret.Allowed)
+	assert.Equal(t, 1, ret.Remaining)
+	ok, _ := replica2.Allow(ctx, "user:1")
+	assert.True(t, ok)
+	ret, _ = replica1.Take(ctx, "user:1")
+	assert.False(t, ret.Allowed)
+	assert.Equal(t, 100*time.Millisecond, ret.RetryAfter)
+	ok, _ = replica2.Allow(ctx, "user:2")
+	assert.True(t, ok)
+
+	// 100ms生成一个令牌
+	mr.SetTime(now.Add(50 * time.Millisecond))
+	ok, _ = replica2.Allow(ctx, "user:1")
+	assert.False(t, ok)
+	mr.SetTime(now.Add(100 * time.Millisecond))
+	ok, _ = replica2.Allow(ctx, "user:1")
+	assert.True(t, ok)
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	clock := newFakeClock()
+	limiter := NewFallbackLimiter(NewRedisLimiter(client, "rl:", 1, 1), NewKeyedLimiter(1, 3, 0, clock), time.Second, 5*time.Second)
+	limiter.clock = clock
+	ctx := context.Background()
+
+	assert.True(t, limiter.Allow(ctx, "a"))
+	assert.False(t, limiter.Allow(ctx, "a"))
+
+	// redis不可用时使用本地限流器
+	mr.Close()
+	assert.True(t, limiter.Allow(ctx, "a"))
+	assert.True(t, limiter.Allow(ctx, "a"))
+	assert.True(t, limiter.Allow(ctx, "a"))
+	assert.False(t, limiter.Allow(ctx, "a"))
+	assert.False(t, limiter.available())
+	clock.Advance(5 * time.Second)
+	assert.True(t, limiter.available())
+}
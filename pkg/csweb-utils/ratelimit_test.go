@@ -0,0 +1,217 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stonejianbu/csweb/protos/csweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestKeyedLimiter_Evict(t *testing.T) {
+	clock := newFakeClock()
+	limiter := NewKeyedLimiter(1, 1, time.Minute, clock)
+	assert.True(t, limiter.Allow("a"))
+	assert.False(t, limiter.Allow("a"))
+	// 不同key互不影响
+	assert.True(t, limiter.Allow("b"))
+	assert.Equal(t, 2, limiter.Len())
+
+	clock.Advance(30 * time.Second)
+	assert.True(t, limiter.Allow("b"))
+	clock.Advance(40 * time.Second)
+	// a 已空闲超过idleTTL被回收，b 仍在使用
+	assert.True(t, limiter.Allow("c"))
+	assert.Equal(t, 2, limiter.Len())
+}
+
+func TestWithRateLimitRules(t *testing.T) {
+	interceptor := WithRateLimitRules(
+		RateLimitRule{Name: "export", Methods: []string{"/order.Order/Export"}, Key: KeyByMethod(), Rate: 1, Burst: 1},
+		RateLimitRule{Name: "tenant", Key: KeyByHeader("x-tenant-id"), Rate: 1, Burst: 2},
+		RateLimitRule{Name: "user", Key: KeyByUID(), Rate: 1, Burst: 1},
+	)
+	call := func(ctx context.Context, method string) codes.Code {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return status.Code(err)
+	}
+	ctx := context.Background()
+
+	// 按方法
+	assert.Equal(t, codes.OK, call(ctx, "/order.Order/Export"))
+	assert.Equal(t, codes.ResourceExhausted, call(ctx, "/order.Order/Export"))
+	assert.Equal(t, codes.OK, call(ctx, "/order.Order/Get"))
+
+	// 按header
+	t1 := metadata.NewIncomingContext(ctx, metadata.Pairs("x-tenant-id", "t1"))
+	t2 := metadata.NewIncomingContext(ctx, metadata.Pairs("x-tenant-id", "t2"))
+	assert.Equal(t, codes.OK, call(t1, "/order.Order/Get"))
+	assert.Equal(t, codes.OK, call(t1, "/order.Order/Get"))
+	assert.Equal(t, codes.ResourceExhausted, call(t1, "/order.Order/Get"))
+	assert.Equal(t, codes.OK, call(t2, "/order.Order/Get"))
+
+	// 按UID
+	u1 := SetClaimsWithContext(ctx, &CustomClaims{UID: "1"})
+	u2 := SetClaimsWithContext(ctx, &CustomClaims{UID: "2"})
+	assert.Equal(t, codes.OK, call(u1, "/order.Order/Get"))
+	assert.Equal(t, codes.ResourceExhausted, call(u1, "/order.Order/Get"))
+	assert.Equal(t, codes.OK, call(u2, "/order.Order/Get"))
+}
+
+func TestKeyByPeerIP(t *testing.T) {
+	direct := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	gateway := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}})
+	xff := metadata.Pairs("x-forwarded-for", "1.1.1.1, 192.168.1.9")
+	tests := []struct {
+		name    string
+		trusted []netip.Prefix
+		ctx     context.Context
+		want    string
+	}{
+		{"peer address", nil, direct, "10.0.0.1"},
+		{"untrusted peer cannot spoof xff", nil, metadata.NewIncomingContext(direct, xff), "10.0.0.1"},
+		{"trusted proxy without xff", []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, gateway, "127.0.0.1"},
+		{"trusted proxy xff", []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, metadata.NewIncomingContext(gateway, xff), "192.168.1.9"},
+		{"peer outside trusted proxies", []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, metadata.NewIncomingContext(direct, xff), "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		ip, ok := KeyByPeerIP(tt.trusted...)(tt.ctx, "")
+		assert.True(t, ok, tt.name)
+		assert.Equal(t, tt.want, ip, tt.name)
+	}
+}
+
+func TestWithRateLimitRules_DefaultKey(t *testing.T) {
+	interceptor := WithRateLimitRules(RateLimitRule{Name: "ip", Rate: 1, Burst: 1})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/Get"}
+	handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+	_, err := interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestRateLimitError_RetryInfo(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucketWithClock(2, 2, clock)
+	ret := bucket.Take()
+	assert.True(t, ret.Allowed)
+	assert.Equal(t, 2, ret.Limit)
+	assert.Equal(t, 1, ret.Remaining)
+	assert.Equal(t, 500*time.Millisecond, ret.ResetAfter)
+	bucket.Take()
+	ret = bucket.Take()
+	assert.False(t, ret.Allowed)
+	assert.Equal(t, 500*time.Millisecond, ret.RetryAfter)
+
+	err := rateLimitError("ratelimit rejected", ret)
+	s := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, s.Code())
+	assert.Len(t, s.Details(), 1)
+	info, ok := s.Details()[0].(*errdetails.RetryInfo)
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, info.GetRetryDelay().AsDuration())
+
+	// 网关响应Retry-After与X-RateLimit-*
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		HeaderMD: metadata.Pairs(RateLimitLimitHeader, "2", RateLimitRemainingHeader, "0", RateLimitResetHeader, "1"),
+	})
+	w := httptest.NewRecorder()
+	CustomErrorHandler(ctx, runtime.NewServeMux(), &runtime.JSONPb{}, w, httptest.NewRequest(http.MethodGet, "/", nil), err)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestRateLimitState_MostRestrictive(t *testing.T) {
+	state := &rateLimitState{}
+	state.record(RateLimitResult{Allowed: true, Limit: 100, Remaining: 80})
+	state.record(RateLimitResult{Allowed: true, Limit: 10, Remaining: 3, ResetAfter: 1500 * time.Millisecond})
+	state.record(RateLimitResult{Allowed: true, Limit: 50, Remaining: 20})
+	md := state.header()
+	assert.Equal(t, []string{"10"}, md.Get(RateLimitLimitHeader))
+	assert.Equal(t, []string{"3"}, md.Get(RateLimitRemainingHeader))
+	assert.Equal(t, []string{"2"}, md.Get(RateLimitResetHeader))
+}
+
+func TestWithRateLimit_Gateway(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(WithRateLimit(1)))
+	csweb.RegisterQuotaAdminServer(server, NewQuotaAdmin(NewMemoryQuotaStore()))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := csweb.NewQuotaAdminClient(conn)
+
+	// 与csweb.go的网关配置及生成的网关代码一致
+	mux := runtime.NewServeMux(
+		runtime.WithErrorHandler(CustomErrorHandler),
+		runtime.WithOutgoingHeaderMatcher(func(key string) (string, bool) {
+			return key, true
+		}),
+	)
+	err = mux.HandlePath(http.MethodGet, "/v1/quota/{key}", func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, csweb.QuotaAdmin_GetQuotaUsage_FullMethodName, runtime.WithHTTPPathPattern("/v1/quota/{key}"))
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		var md runtime.ServerMetadata
+		resp, err := client.GetQuotaUsage(ctx, &csweb.GetQuotaUsageReq{Key: params["key"]}, grpc.Header(&md.HeaderMD), grpc.Trailer(&md.TrailerMD))
+		ctx = runtime.NewServerMetadataContext(ctx, md)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, resp)
+	})
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/quota/t1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	// 被拒绝的调用通过grpc.SetHeader返回的header写入429响应
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/quota/t1", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
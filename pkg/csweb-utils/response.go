@@ -6,6 +6,7 @@ package csweb_utils
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/stonejianbu/csweb/protos/common"
 	"google.golang.org/grpc/codes"
@@ -33,15 +34,15 @@ func NotFoundError(err error) error {
 }
 
 func QueryError(obj string) error {
-	return status.Errorf(codes.Internal, "query %s failed, try again later", obj)
+	return messageError(codes.Internal, "QUERY_FAILED", fmt.Sprintf("query %s failed, try again later", obj), "obj", obj)
 }
 
 func UpdateError(obj string) error {
-	return status.Errorf(codes.Internal, "update %s failed, try again later", obj)
+	return messageError(codes.Internal, "UPDATE_FAILED", fmt.Sprintf("update %s failed, try again later", obj), "obj", obj)
 }
 
 func DeleteError(obj string) error {
-	return status.Errorf(codes.Internal, "delete %s failed, try again later", obj)
+	return messageError(codes.Internal, "DELETE_FAILED", fmt.Sprintf("delete %s failed, try again later", obj), "obj", obj)
 }
 
 func ArgumentError(format string, a ...any) error {
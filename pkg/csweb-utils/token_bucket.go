@@ -6,57 +6,153 @@ package csweb_utils
 
 import (
 	"context"
+	"math"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-// TokenBucket 令牌桶结构体
+// Clock 时间源，测试时可替换为假时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock 系统时钟
+var SystemClock Clock = systemClock{}
+
+// TokenBucket 令牌桶，并发安全，按经过的时间连续(含小数)补充令牌。
+// 不再导出Num/Size/Rate/UpdateTime字段(并发读写不安全)，改用 Tokens/Take 等方法
 type TokenBucket struct {
-	Num        int           // 当前桶中的令牌数据
-	Size       int           // 木桶中令牌的容量
-	Rate       time.Duration // 生成一个令牌所需要的时间
-	UpdateTime time.Time     // 记录每次令牌桶更新时间
+	mu     sync.Mutex
+	rate   float64   // 每秒生成的令牌数
+	burst  float64   // 木桶中令牌的容量
+	tokens float64   // 当前桶中的令牌数
+	last   time.Time // 上次补充令牌的时间
+	clock  Clock
 }
 
-// NewTokenBucket 创建令牌桶
+// NewTokenBucket 创建令牌桶，size为桶容量，每rate时间生成一个令牌，初始时桶是满的
+//
+// Deprecated: use NewTokenBucketWithRate, which takes the refill rate in tokens per second and the burst separately.
 func NewTokenBucket(size int, rate time.Duration) *TokenBucket {
+	if rate <= 0 {
+		return NewTokenBucketWithRate(0, size)
+	}
+	return NewTokenBucketWithRate(float64(time.Second)/float64(rate), size)
+}
+
+// NewTokenBucketWithRate 创建令牌桶，rate为每秒生成的令牌数，burst为桶容量，初始时桶是满的
+func NewTokenBucketWithRate(rate float64, burst int) *TokenBucket {
+	return NewTokenBucketWithClock(rate, burst, SystemClock)
+}
+
+// NewTokenBucketWithClock 使用指定时钟创建令牌桶
+func NewTokenBucketWithClock(rate float64, burst int, clock Clock) *TokenBucket {
 	return &TokenBucket{
-		Num:        size,
-		Size:       size,
-		Rate:       rate,
-		UpdateTime: time.Now(),
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+		clock:  clock,
 	}
 }
 
-// Limit 验证是否能获取一个令牌
-func (that *TokenBucket) Limit(_ context.Context) bool {
-	now := time.Now()
-	// 如果与上次请求的时间间隔超过了token rate则增加令牌，最大令牌数不超过桶容量
-	if that.UpdateTime.Add(that.Rate).Before(now) {
-		that.Num += int(now.Sub(that.UpdateTime) / that.Rate)
-		if that.Num > that.Size {
-			that.Num = that.Size
+// refill 补充从上次更新到now之间生成的令牌，调用方需持有锁
+func (that *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(that.last); elapsed > 0 {
+		that.tokens += elapsed.Seconds() * that.rate
+		if that.tokens > that.burst {
+			that.tokens = that.burst
 		}
-		// 更新last
-		that.UpdateTime = now
+		that.last = now
+	}
+}
+
+// RateLimitResult 一次限流判断的结果
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // 突发容量
+	Remaining  int           // 剩余可用的请求数
+	RetryAfter time.Duration // 被拒绝时距离下一个令牌生成的时间
+	ResetAfter time.Duration // 距离令牌桶回满的时间
+}
+
+// TakeN 尝试获取n个令牌，并返回剩余配额
+func (that *TokenBucket) TakeN(n int) RateLimitResult {
+	return that.TakeShare(n, 1)
+}
+
+// TakeShare 尝试获取n个令牌，但只能使用桶容量的share比例，其余令牌为高优先级的请求保留
+func (that *TokenBucket) TakeShare(n int, share float64) RateLimitResult {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.refill(that.clock.Now())
+	ret := RateLimitResult{Limit: int(that.burst)}
+	need := float64(n) + that.burst*(1-share)
+	if that.tokens >= need {
+		that.tokens -= float64(n)
+		ret.Allowed = true
+	} else if that.rate > 0 {
+		ret.RetryAfter = secondsToDuration((need - that.tokens) / that.rate)
 	}
-	if that.Num <= 0 {
-		return true
+	ret.Remaining = int(that.tokens)
+	if that.rate > 0 {
+		ret.ResetAfter = secondsToDuration((that.burst - that.tokens) / that.rate)
 	}
-	that.Num--
-	return false
+	return ret
+}
+
+// Take 尝试获取一个令牌，并返回剩余配额
+func (that *TokenBucket) Take() RateLimitResult {
+	return that.TakeN(1)
+}
+
+// AllowN 尝试获取n个令牌，成功返回true
+func (that *TokenBucket) AllowN(n int) bool {
+	return that.TakeN(n).Allowed
+}
+
+// Allow 尝试获取一个令牌，成功返回true
+func (that *TokenBucket) Allow() bool {
+	return that.AllowN(1)
+}
+
+// Tokens 当前可用的令牌数
+func (that *TokenBucket) Tokens() float64 {
+	that.mu.Lock()
+	defer that.mu.Unlock()
+	that.refill(that.clock.Now())
+	return that.tokens
+}
+
+// Limit 验证是否被限流，无可用令牌时返回true
+func (that *TokenBucket) Limit(_ context.Context) bool {
+	return !that.Allow()
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
 }
 
 // WithRateLimit return a new unary server interceptors that performs request rate limiting.
+// num 为每秒允许的请求数，同时也是允许的突发请求数；启用优先级时为高优先级的请求保留令牌。
+//
+// 不兼容变更: 之前的实现中num只是突发容量，令牌每秒补充1个；现在每秒补充num个，
+// 依赖旧行为的调用方需改用 WithRateLimitRules 分别设置Rate和Burst
 func WithRateLimit(num int) grpc.UnaryServerInterceptor {
-	limiter := NewTokenBucket(num, time.Second)
+	limiter := NewTokenBucketWithRate(float64(num), num)
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		if limiter.Limit(ctx) {
-			return nil, status.Errorf(codes.ResourceExhausted, "ratelimit rejected, please retry later")
+		ret := limiter.TakeShare(1, admissionShare(ctx))
+		if !ret.Allowed {
+			recordShed(ctx, "ratelimit")
 		}
-		return handler(ctx, req)
+		return serveWithRateLimit(ctx, req, handler, "ratelimit rejected, please retry later", ret)
 	}
 }
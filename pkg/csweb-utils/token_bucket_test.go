@@ -0,0 +1,113 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTokenBucket_Burst(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucketWithClock(1, 3, clock)
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+	// 长时间空闲后不超过容量
+	clock.Advance(time.Hour)
+	assert.Equal(t, float64(3), bucket.Tokens())
+}
+
+func TestNewTokenBucket_Deprecated(t *testing.T) {
+	// 旧签名: 容量3，每500ms生成一个令牌
+	bucket := NewTokenBucket(3, 500*time.Millisecond)
+	assert.Equal(t, float64(2), bucket.rate)
+	assert.Equal(t, float64(3), bucket.Tokens())
+	assert.Equal(t, float64(0), NewTokenBucket(1, 0).rate)
+}
+
+func TestTokenBucket_FractionalRefill(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucketWithClock(10, 1, clock)
+	assert.True(t, bucket.Allow())
+	assert.False(t, bucket.Allow())
+	// 每次推进半个令牌的时间，小数部分不能丢失
+	clock.Advance(50 * time.Millisecond)
+	assert.False(t, bucket.Allow())
+	clock.Advance(50 * time.Millisecond)
+	assert.True(t, bucket.Allow())
+	assert.InDelta(t, 0, bucket.Tokens(), 1e-9)
+
+	// 速率低于1个/秒
+	slow := NewTokenBucketWithClock(0.5, 1, clock)
+	assert.True(t, slow.Allow())
+	clock.Advance(time.Second)
+	assert.False(t, slow.Allow())
+	clock.Advance(time.Second)
+	assert.True(t, slow.Allow())
+}
+
+func TestTokenBucket_AllowN(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucketWithClock(2, 5, clock)
+	assert.True(t, bucket.AllowN(5))
+	assert.False(t, bucket.AllowN(2))
+	clock.Advance(time.Second)
+	assert.True(t, bucket.AllowN(2))
+	assert.False(t, bucket.AllowN(1))
+}
+
+func TestTokenBucket_Concurrent(t *testing.T) {
+	clock := newFakeClock()
+	bucket := NewTokenBucketWithClock(100, 50, clock)
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if bucket.Allow() {
+					admitted.Add(1)
+				}
+				if j%10 == 0 {
+					clock.Advance(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 最多允许 burst + 经过时间 * rate 个请求
+	elapsed := 20 * 10 * time.Millisecond
+	assert.LessOrEqual(t, admitted.Load(), int64(50+elapsed.Seconds()*100))
+	assert.GreaterOrEqual(t, admitted.Load(), int64(50))
+}
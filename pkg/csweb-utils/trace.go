@@ -6,10 +6,20 @@ package csweb_utils
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	stdout "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	"go.opentelemetry.io/otel/exporters/zipkin"
 	"go.opentelemetry.io/otel/propagation"
@@ -17,65 +27,345 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
 )
 
-// InitTracerProvider init tracer Provider
-func InitTracerProvider(name, addr string) error {
-	var exporter sdktrace.SpanExporter
-	var err error
-	if len(addr) != 0 {
-		exporter, err = zipkin.New(addr)
-		if err != nil {
-			return err
+const (
+	TraceExporterNone     = "none"     // 不导出span，仍生成traceId用于日志和响应
+	TraceExporterStdout   = "stdout"   // 输出到标准输出，仅用于本地调试
+	TraceExporterZipkin   = "zipkin"   // Endpoint为 http://host:9411/api/v2/spans
+	TraceExporterOTLPGrpc = "otlpgrpc" // Endpoint为 host:4317 或 URL
+	TraceExporterOTLPHttp = "otlphttp" // Endpoint为 host:4318 或 URL
+)
+
+// MethodSampleRule 按grpc方法设置采样率，Method支持path.Match通配符，如 /grpc.health.v1.Health/*，
+// 网关请求按路由对应的grpc方法匹配
+type MethodSampleRule struct {
+	Method string
+	Ratio  float64
+}
+
+// TracerConfig 链路追踪配置
+type TracerConfig struct {
+	Exporter       string             // 导出方式，默认none
+	Endpoint       string             // 导出地址，OTLP为空时使用OTEL_EXPORTER_OTLP_ENDPOINT等环境变量
+	Insecure       bool               // OTLP不使用TLS
+	Headers        map[string]string  // OTLP请求头，如鉴权token
+	SampleRatio    *float64           // 根span的采样率，nil时为1，存在父span时沿用父span的采样决定
+	MethodSampling []MethodSampleRule // 按方法覆盖采样率，按顺序匹配
+}
+
+// methodSampler 按rpc.service和rpc.method属性(网关span)或span名称(otelgrpc为 package.Service/Method)
+// 匹配采样规则，未匹配时使用默认采样率
+type methodSampler struct {
+	rules    []MethodSampleRule
+	samplers []sdktrace.Sampler
+	fallback sdktrace.Sampler
+}
+
+func newMethodSampler(ratio float64, rules []MethodSampleRule) sdktrace.Sampler {
+	s := &methodSampler{rules: rules, fallback: sdktrace.TraceIDRatioBased(ratio)}
+	for _, rule := range rules {
+		s.samplers = append(s.samplers, sdktrace.TraceIDRatioBased(rule.Ratio))
+	}
+	return s
+}
+
+func (that *methodSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	method := sampledMethod(p)
+	for i, rule := range that.rules {
+		if ok, _ := path.Match(rule.Method, method); ok {
+			return that.samplers[i].ShouldSample(p)
 		}
-	} else {
-		exporter, err = stdout.New()
-		if err != nil {
-			return err
+	}
+	return that.fallback.ShouldSample(p)
+}
+
+// sampledMethod 采样参数对应的grpc方法，如 /package.Service/Method
+func sampledMethod(p sdktrace.SamplingParameters) string {
+	var service, method string
+	for _, attr := range p.Attributes {
+		switch attr.Key {
+		case semconv.RPCServiceKey:
+			service = attr.Value.AsString()
+		case semconv.RPCMethodKey:
+			method = attr.Value.AsString()
 		}
 	}
+	if len(service) > 0 && len(method) > 0 {
+		return "/" + service + "/" + method
+	}
+	return "/" + strings.TrimPrefix(p.Name, "/")
+}
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithBatcher(exporter),
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceName(name),
-		)),
-	)
+func (that *methodSampler) Description() string {
+	return fmt.Sprintf("MethodSampler{rules:%d,fallback:%s}", len(that.rules), that.fallback.Description())
+}
+
+// newTraceExporter 创建span导出器，none返回nil
+func newTraceExporter(conf TracerConfig) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+	isURL := strings.Contains(conf.Endpoint, "://")
+	switch conf.Exporter {
+	case "", TraceExporterNone:
+		return nil, nil
+	case TraceExporterStdout:
+		return stdout.New()
+	case TraceExporterZipkin:
+		return zipkin.New(conf.Endpoint)
+	case TraceExporterOTLPGrpc:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(conf.Headers)}
+		if isURL {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(conf.Endpoint))
+		} else if len(conf.Endpoint) > 0 {
+			opts = append(opts, otlptracegrpc.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case TraceExporterOTLPHttp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(conf.Headers)}
+		if isURL {
+			opts = append(opts, otlptracehttp.WithEndpointURL(conf.Endpoint))
+		} else if len(conf.Endpoint) > 0 {
+			opts = append(opts, otlptracehttp.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", conf.Exporter)
+	}
+}
+
+// newResource 服务的资源属性，合并resource.Default()，可通过OTEL_RESOURCE_ATTRIBUTES补充部署环境等属性，
+// service.name 使用name
+func newResource(name string) (*resource.Resource, error) {
+	return resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(name),
+	))
+}
+
+// NewTracerProvider 按配置创建TracerProvider，调用方负责在退出时Shutdown以导出剩余的span
+func NewTracerProvider(name string, conf TracerConfig) (*sdktrace.TracerProvider, error) {
+	res, err := newResource(name)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := newTraceExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	ratio := 1.0
+	if conf.SampleRatio != nil {
+		ratio = *conf.SampleRatio
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(newMethodSampler(ratio, conf.MethodSampling))),
+		sdktrace.WithResource(res),
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
+
+// InitTracer 创建并设置全局的TracerProvider和propagator，返回的shutdown用于退出时导出剩余的span
+func InitTracer(name string, conf TracerConfig) (shutdown func(context.Context) error, err error) {
+	tp, err := NewTracerProvider(name, conf)
+	if err != nil {
+		return nil, err
+	}
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-	return nil
+	return tp.Shutdown, nil
+}
+
+// InitTracerProvider init tracer Provider, addr不为空时导出到zipkin，否则不导出
+//
+// Deprecated: use InitTracer, which supports OTLP exporters and sampling, and returns the shutdown function.
+func InitTracerProvider(name, addr string) error {
+	conf := TracerConfig{Exporter: TraceExporterNone}
+	if len(addr) != 0 {
+		conf = TracerConfig{Exporter: TraceExporterZipkin, Endpoint: addr}
+	}
+	_, err := InitTracer(name, conf)
+	return err
+}
+
+// statusResponseWriter 记录响应状态码和body大小
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status       int
+	size         int
+	beforeHeader func() // 写入响应头之前调用，用于补充响应头
+}
+
+func (that *statusResponseWriter) WriteHeader(code int) {
+	if that.status == 0 {
+		that.status = code
+		if that.beforeHeader != nil {
+			that.beforeHeader()
+		}
+	}
+	that.ResponseWriter.WriteHeader(code)
+}
+
+func (that *statusResponseWriter) Write(b []byte) (int, error) {
+	if that.status == 0 {
+		that.WriteHeader(http.StatusOK)
+	}
+	n, err := that.ResponseWriter.Write(b)
+	that.size += n
+	return n, err
+}
+
+func (that *statusResponseWriter) Flush() {
+	if f, ok := that.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (that *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return that.ResponseWriter
 }
 
-// WithTrace inject traceId for customErrorHandler
+// Status 未写入响应时视为200
+func (that *statusResponseWriter) Status() int {
+	if that.status == 0 {
+		return http.StatusOK
+	}
+	return that.status
+}
+
+// gatewaySpan 网关请求的server span。路由之前不知道grpc方法和路由模板，span延迟到路由后创建，
+// 使按方法的采样规则对网关请求同样生效；创建之前委托给上游的span
+type gatewaySpan struct {
+	trace.Span
+	started bool
+	tracer  trace.Tracer
+	parent  context.Context
+	start   time.Time
+	attrs   []attribute.KeyValue
+}
+
+// begin 创建span，已创建时返回false
+func (that *gatewaySpan) begin(name string, attrs ...attribute.KeyValue) bool {
+	if that.started {
+		return false
+	}
+	that.started = true
+	_, that.Span = that.tracer.Start(that.parent, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(that.start),
+		trace.WithAttributes(append(that.attrs, attrs...)...),
+	)
+	return true
+}
+
+// WithTrace 为网关请求创建HTTP server span: 从请求头(traceparent)提取上游的链路，
+// 响应头中写入traceparent，并记录状态码；span在路由后由TraceRoute以路由模板命名并创建，
+// 未匹配路由的请求在写入响应时以请求方法命名
 func WithTrace(h http.Handler) http.Handler {
+	tracer := otel.GetTracerProvider().Tracer("http")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		if span := trace.SpanContextFromContext(ctx); !span.IsSampled() {
-			attrs := make([]attribute.KeyValue, 0)
-			attrs = append(attrs, attribute.String("method", r.Method))
-			attrs = append(attrs, attribute.String("remoteAddr", r.RemoteAddr))
-			attrs = append(attrs, attribute.String("userAgent", r.UserAgent()))
-			attrs = append(attrs, attribute.String("url", r.URL.String()))
-			tracer := otel.GetTracerProvider().Tracer("http")
-			_, span := tracer.Start(
-				ctx,
-				r.URL.Path,
-				trace.WithSpanKind(trace.SpanKindClient),
-				trace.WithAttributes(attrs...),
-			)
-			defer span.End()
-			ctx = trace.ContextWithSpan(ctx, span)
+		propagator := otel.GetTextMapPropagator()
+		parent := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLScheme(scheme),
+			semconv.URLPath(r.URL.Path),
+			semconv.ServerAddress(r.Host),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		}
+		span := &gatewaySpan{
+			Span:   trace.SpanFromContext(parent),
+			tracer: tracer,
+			parent: parent,
+			start:  time.Now(),
+			attrs:  append(attrs, clientAttributes(r.RemoteAddr)...),
+		}
+		ctx := trace.ContextWithSpan(parent, span)
+		defer func() {
+			span.begin(r.Method)
+			span.End()
+		}()
+		rw := &statusResponseWriter{ResponseWriter: w, beforeHeader: func() {
+			span.begin(r.Method)
+			propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+		}}
+		h.ServeHTTP(rw, r.WithContext(ctx))
+		// 未写入响应时显式写入200，确保响应头中有traceparent
+		if rw.status == 0 {
+			rw.WriteHeader(http.StatusOK)
+		}
+		code := rw.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(code))
+		// 4xx是客户端错误，server span只将5xx标记为错误
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
 		}
-		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// clientAttributes 将RemoteAddr拆分为client.address和client.port
+func clientAttributes(remoteAddr string) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return []attribute.KeyValue{semconv.ClientAddress(remoteAddr)}
+	}
+	attrs := []attribute.KeyValue{semconv.ClientAddress(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ClientPort(p))
+	}
+	return attrs
+}
+
+// TraceRoute 网关匹配路由后，以 "方法 路由模板" 作为span名称，如 GET /v1/users/{id}，并记录对应的grpc方法，
+// 通过runtime.WithMetadata注册；直接通过mux.HandlePath注册的路由没有模板，需改用 HandlePath 注册
+func TraceRoute(ctx context.Context, r *http.Request) metadata.MD {
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		var attrs []attribute.KeyValue
+		if fullMethod, ok := runtime.RPCMethod(ctx); ok {
+			attrs = rpcAttributes(fullMethod)
+		}
+		setSpanRoute(ctx, r.Method, pattern, attrs...)
+	}
+	return nil
+}
+
+// rpcAttributes 将 /package.Service/Method 拆分为rpc.service和rpc.method
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil
+	}
+	return []attribute.KeyValue{semconv.RPCService(service), semconv.RPCMethod(method)}
+}
+
+// setSpanRoute 网关span尚未创建时以路由创建，否则更新span名称
+func setSpanRoute(ctx context.Context, method, pattern string, attrs ...attribute.KeyValue) {
+	name := method + " " + pattern
+	attrs = append(attrs, semconv.HTTPRoute(pattern))
+	span := trace.SpanFromContext(ctx)
+	if gateway, ok := span.(*gatewaySpan); ok && gateway.begin(name, attrs...) {
+		return
+	}
+	span.SetName(name)
+	span.SetAttributes(attrs...)
+}
+
 // GetTraceId get traceId from context
 func GetTraceId(ctx context.Context) string {
 	traceId := ""
-	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
 		traceId = span.TraceID().String()
 	}
 	return traceId
@@ -0,0 +1,151 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestMethodSampler(t *testing.T) {
+	zero := 0.0
+	tp, err := NewTracerProvider("test", TracerConfig{
+		SampleRatio:    &zero,
+		MethodSampling: []MethodSampleRule{{Method: "/csweb.Order/*", Ratio: 1}},
+	})
+	assert.Nil(t, err)
+	defer tp.Shutdown(context.Background())
+	tracer := tp.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "csweb.Order/Create")
+	assert.True(t, span.SpanContext().IsSampled())
+	assert.True(t, span.SpanContext().TraceID().IsValid())
+	span.End()
+
+	// 未匹配规则时使用默认采样率，不采样仍生成traceId
+	ctx, span := tracer.Start(context.Background(), "csweb.User/Get")
+	assert.False(t, span.SpanContext().IsSampled())
+	assert.True(t, span.SpanContext().TraceID().IsValid())
+	// 子span沿用父span的采样决定
+	_, child := tracer.Start(ctx, "csweb.Order/Create")
+	assert.False(t, child.SpanContext().IsSampled())
+	child.End()
+	span.End()
+
+	remote := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+	_, span = tracer.Start(remote, "csweb.User/Get")
+	assert.True(t, span.SpanContext().IsSampled())
+	span.End()
+}
+
+func TestNewTraceExporter(t *testing.T) {
+	exporter, err := newTraceExporter(TracerConfig{Exporter: TraceExporterNone})
+	assert.Nil(t, err)
+	assert.Nil(t, exporter)
+
+	exporter, err = newTraceExporter(TracerConfig{Exporter: TraceExporterOTLPHttp, Endpoint: "http://127.0.0.1:4318", Insecure: true})
+	assert.Nil(t, err)
+	assert.Implements(t, (*sdktrace.SpanExporter)(nil), exporter)
+
+	_, err = newTraceExporter(TracerConfig{Exporter: "jaeger"})
+	assert.NotNil(t, err)
+}
+
+func TestWithTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := runtime.NewServeMux(runtime.WithMetadata(TraceRoute))
+	handler := WithTrace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 模拟生成的网关代码在路由后注入路由模板
+		_, err := runtime.AnnotateContext(r.Context(), mux, r, "/csweb.User/Get", runtime.WithHTTPPathPattern("/v1/users/{id}"))
+		assert.Nil(t, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/1", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /v1/users/{id}", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", span.Parent().SpanID().String())
+	assert.Contains(t, span.Attributes(), semconv.HTTPRoute("/v1/users/{id}"))
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(http.StatusServiceUnavailable))
+	// httptest的RemoteAddr为 192.0.2.1:1234
+	assert.Contains(t, span.Attributes(), semconv.ClientAddress("192.0.2.1"))
+	assert.Contains(t, span.Attributes(), semconv.ClientPort(1234))
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, w.Header().Get("traceparent"), span.SpanContext().SpanID().String())
+}
+
+func TestWithTrace_MethodSampling(t *testing.T) {
+	zero := 0.0
+	tp, err := NewTracerProvider("test", TracerConfig{
+		SampleRatio:    &zero,
+		MethodSampling: []MethodSampleRule{{Method: "/csweb.Order/*", Ratio: 1}},
+	})
+	assert.Nil(t, err)
+	defer tp.Shutdown(context.Background())
+	recorder := tracetest.NewSpanRecorder()
+	tp.RegisterSpanProcessor(recorder)
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	mux := runtime.NewServeMux(runtime.WithMetadata(TraceRoute))
+	routes := map[string]string{"/v1/orders/{id}": "/csweb.Order/Get", "/v1/users/{id}": "/csweb.User/Get"}
+	for pattern, method := range routes {
+		err := mux.HandlePath(http.MethodGet, pattern, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			// 与生成的网关代码一致，路由后注入grpc方法和路由模板
+			ctx, err := runtime.AnnotateContext(r.Context(), mux, r, method, runtime.WithHTTPPathPattern(pattern))
+			assert.Nil(t, err)
+			_, client := otel.Tracer("grpc").Start(ctx, strings.TrimPrefix(method, "/"))
+			client.End()
+		})
+		assert.Nil(t, err)
+	}
+	handler := WithTrace(mux)
+	for _, target := range []string{"/v1/orders/1", "/v1/users/1", "/v1/unknown"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.NotEqual(t, http.StatusInternalServerError, w.Code, target)
+	}
+
+	// 只有匹配规则的方法被采样，grpc调用的span沿用网关span的采样决定
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "csweb.Order/Get", client.Name())
+	assert.Equal(t, "GET /v1/orders/{id}", server.Name())
+	assert.Equal(t, server.SpanContext().SpanID(), client.Parent().SpanID())
+	assert.Contains(t, server.Attributes(), semconv.RPCService("csweb.Order"))
+	assert.Contains(t, server.Attributes(), semconv.RPCMethod("Get"))
+}
@@ -7,27 +7,191 @@ package csweb_utils
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/bufbuild/protovalidate-go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
-func WithValidator() grpc.UnaryServerInterceptor {
-	validator, _ := protovalidate.New()
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		switch msg := req.(type) {
-		case proto.Message:
-			if err = validator.Validate(msg); err != nil {
-				return nil, status.Error(codes.InvalidArgument, err.Error())
+// SelfValidator 自带校验逻辑的消息，如protoc-gen-validate生成的消息或手写的非proto请求
+type SelfValidator interface {
+	Validate() error
+}
+
+// validateFunc 自定义校验函数，applied为false表示不适用于该消息
+type validateFunc func(ctx context.Context, msg any) (applied bool, err error)
+
+// Validator 请求(及可选的响应)消息校验：protovalidate注解、SelfValidator以及自定义校验函数
+type Validator struct {
+	protoValidator   *protovalidate.Validator
+	protoOpts        []protovalidate.ValidatorOption
+	celLibraries     []*CELLibrary
+	funcs            []validateFunc
+	validateResponse bool
+}
+
+type ValidatorOption func(v *Validator)
+
+// ValidateFor 为类型T(具体消息类型或接口)注册自定义校验函数，在protovalidate之后执行
+func ValidateFor[T any](fn func(ctx context.Context, msg T) error) ValidatorOption {
+	return func(v *Validator) {
+		v.funcs = append(v.funcs, func(ctx context.Context, msg any) (bool, error) {
+			m, ok := msg.(T)
+			if !ok {
+				return false, nil
 			}
-		default:
-			return nil, errors.New("unsupported message type")
+			return true, fn(ctx, m)
+		})
+	}
+}
+
+// ValidateResponse 同时校验响应消息，校验失败返回Internal，用于开发调试
+func ValidateResponse() ValidatorOption {
+	return func(v *Validator) {
+		v.validateResponse = true
+	}
+}
+
+// WithProtovalidateOptions 传递protovalidate的选项，如 protovalidate.WithFailFast
+func WithProtovalidateOptions(opts ...protovalidate.ValidatorOption) ValidatorOption {
+	return func(v *Validator) {
+		v.protoOpts = append(v.protoOpts, opts...)
+	}
+}
+
+// NewValidator 创建校验器，protovalidate初始化失败或CEL规则库中的约束无法展开时返回error
+func NewValidator(opts ...ValidatorOption) (*Validator, error) {
+	v := &Validator{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	for _, library := range v.celLibraries {
+		if err := library.check(protoregistry.GlobalFiles); err != nil {
+			return nil, fmt.Errorf("init cel library failed: %w", err)
+		}
+	}
+	protoValidator, err := protovalidate.New(v.protoOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("init protovalidate failed: %w", err)
+	}
+	v.protoValidator = protoValidator
+	return v, nil
+}
+
+// Validate 依次执行protovalidate、SelfValidator和自定义校验函数，
+// 非proto消息必须至少有一种适用的校验方式，否则视为服务端配置错误
+func (that *Validator) Validate(ctx context.Context, msg any) error {
+	checked := false
+	if m, ok := msg.(proto.Message); ok {
+		checked = true
+		if err := that.protoValidator.Validate(m); err != nil {
+			return validationError(ctx, err)
+		}
+	}
+	if m, ok := msg.(SelfValidator); ok {
+		checked = true
+		if err := m.Validate(); err != nil {
+			return customValidationError(ctx, err)
+		}
+	}
+	for _, fn := range that.funcs {
+		applied, err := fn(ctx, msg)
+		if !applied {
+			continue
+		}
+		checked = true
+		if err != nil {
+			return customValidationError(ctx, err)
 		}
+	}
+	if !checked {
+		return status.Errorf(codes.Internal, "unsupported message type %T, register a validator with ValidateFor", msg)
+	}
+	return nil
+}
 
-		return handler(ctx, req)
+// UnaryServerInterceptor 校验请求，启用ValidateResponse时同时校验响应
+func (that *Validator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := that.Validate(ctx, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil || !that.validateResponse || isNilMessage(resp) {
+			return resp, err
+		}
+		if respErr := that.Validate(ctx, resp); respErr != nil {
+			logrus.Errorf("invalid response of %s: %v", info.FullMethod, respErr)
+			return nil, status.Errorf(codes.Internal, "invalid response of %s: %s", info.FullMethod, status.Convert(respErr).Message())
+		}
+		return resp, nil
+	}
+}
+
+// isNilMessage 响应是否为nil，包括handler返回的 (*Resp)(nil)
+func isNilMessage(resp any) bool {
+	if resp == nil {
+		return true
 	}
+	msg, ok := resp.(proto.Message)
+	return ok && !msg.ProtoReflect().IsValid()
+}
 
+// WithValidator return a new unary server interceptor that validates requests by protovalidate.
+//
+// Deprecated: use NewValidator so that the initialization error can be handled, WithValidator panics on error.
+func WithValidator() grpc.UnaryServerInterceptor {
+	validator, err := NewValidator()
+	if err != nil {
+		panic(err)
+	}
+	return validator.UnaryServerInterceptor()
+}
+
+// validationError 将protovalidate的校验错误转换为InvalidArgument，并以BadRequest携带每个字段的错误，
+// 启用WithI18n时字段错误和LocalizedMessage按请求的语言翻译；编译或执行约束失败属于服务端错误，返回Internal
+func validationError(ctx context.Context, err error) error {
+	var valErr *protovalidate.ValidationError
+	if !errors.As(err, &valErr) {
+		return status.Errorf(codes.Internal, "validate failed: %v", err)
+	}
+	catalog, localized := i18nCatalog(ctx)
+	tag := LocaleFromContext(ctx)
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range valErr.Violations {
+		description := violation.GetMessage()
+		if localized {
+			description = localizeViolation(catalog, tag, violation.GetFieldPath(), violation.GetConstraintId(), description)
+		}
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.GetFieldPath(),
+			Description: description,
+		})
+	}
+	st, detailErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(badRequest)
+	if detailErr != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !localized {
+		return st.Err()
+	}
+	return localizeError(catalog, tag, st.Err())
+}
+
+// customValidationError 自定义校验返回status错误时原样返回，否则转换为InvalidArgument
+func customValidationError(ctx context.Context, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	err = status.Error(codes.InvalidArgument, err.Error())
+	if catalog, ok := i18nCatalog(ctx); ok {
+		return localizeError(catalog, LocaleFromContext(ctx), err)
+	}
+	return err
 }
@@ -0,0 +1,101 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package csweb_utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stonejianbu/csweb/protos/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithValidator_FieldViolations(t *testing.T) {
+	interceptor := WithValidator()
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/List"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	resp, err := interceptor(context.Background(), &common.Page{Page: 1, PerPage: 10}, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = interceptor(context.Background(), &common.Page{Page: 0, PerPage: 10}, info, handler)
+	s := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, s.Code())
+	assert.Len(t, s.Details(), 1)
+	badRequest, ok := s.Details()[0].(*errdetails.BadRequest)
+	assert.True(t, ok)
+	assert.Equal(t, "page", badRequest.GetFieldViolations()[0].GetField())
+
+	// 网关响应中返回fieldErrors
+	w := httptest.NewRecorder()
+	CustomErrorHandler(context.Background(), runtime.NewServeMux(), &runtime.JSONPb{}, w, httptest.NewRequest(http.MethodGet, "/", nil), err)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	errResp := ErrResp{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, int32(codes.InvalidArgument), errResp.Status.Code)
+	assert.Len(t, errResp.FieldErrors, 1)
+	assert.Equal(t, "page", errResp.FieldErrors[0].Field)
+	assert.NotEmpty(t, errResp.FieldErrors[0].Message)
+}
+
+type signupReq struct {
+	Email string
+}
+
+func (r *signupReq) Validate() error {
+	if len(r.Email) == 0 {
+		return errors.New("email is required")
+	}
+	return nil
+}
+
+func TestValidator_Custom(t *testing.T) {
+	validator, err := NewValidator(
+		ValidateFor(func(ctx context.Context, page *common.Page) error {
+			if page.GetPerPage() > 100 {
+				return status.Error(codes.OutOfRange, "perPage must be at most 100")
+			}
+			return nil
+		}),
+		ValidateResponse(),
+	)
+	assert.Nil(t, err)
+	interceptor := validator.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/order.Order/List"}
+	echo := func(ctx context.Context, req any) (any, error) { return req, nil }
+
+	// 自定义校验在protovalidate之后执行
+	_, err = interceptor(context.Background(), &common.Page{Page: 1, PerPage: 200}, info, echo)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+	// 非proto消息通过Validate()校验
+	_, err = interceptor(context.Background(), &signupReq{}, info, echo)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = interceptor(context.Background(), &signupReq{Email: "a@b.c"}, info, echo)
+	assert.Nil(t, err)
+	// 没有适用校验方式的非proto消息属于配置错误
+	_, err = interceptor(context.Background(), struct{}{}, info, echo)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	// 响应校验失败返回Internal
+	_, err = interceptor(context.Background(), &common.Page{Page: 1, PerPage: 10}, info, func(ctx context.Context, req any) (any, error) {
+		return &common.Page{Page: 0, PerPage: 10}, nil
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	// handler返回 (*Resp)(nil) 时不校验响应
+	resp, err := interceptor(context.Background(), &common.Page{Page: 1, PerPage: 10}, info, func(ctx context.Context, req any) (any, error) {
+		return (*common.Page)(nil), nil
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 huangyouguang <stonehuang90@gmail.com>. All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.15.8
+// source: csweb/quota.proto
+
+package csweb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	QuotaAdmin_GetQuotaUsage_FullMethodName   = "/csweb.QuotaAdmin/GetQuotaUsage"
+	QuotaAdmin_ResetQuotaUsage_FullMethodName = "/csweb.QuotaAdmin/ResetQuotaUsage"
+)
+
+// QuotaAdminClient is the client API for QuotaAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type QuotaAdminClient interface {
+	GetQuotaUsage(ctx context.Context, in *GetQuotaUsageReq, opts ...grpc.CallOption) (*GetQuotaUsageResp, error)
+	ResetQuotaUsage(ctx context.Context, in *ResetQuotaUsageReq, opts ...grpc.CallOption) (*ResetQuotaUsageResp, error)
+}
+
+type quotaAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewQuotaAdminClient(cc grpc.ClientConnInterface) QuotaAdminClient {
+	return &quotaAdminClient{cc}
+}
+
+func (c *quotaAdminClient) GetQuotaUsage(ctx context.Context, in *GetQuotaUsageReq, opts ...grpc.CallOption) (*GetQuotaUsageResp, error) {
+	out := new(GetQuotaUsageResp)
+	err := c.cc.Invoke(ctx, QuotaAdmin_GetQuotaUsage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *quotaAdminClient) ResetQuotaUsage(ctx context.Context, in *ResetQuotaUsageReq, opts ...grpc.CallOption) (*ResetQuotaUsageResp, error) {
+	out := new(ResetQuotaUsageResp)
+	err := c.cc.Invoke(ctx, QuotaAdmin_ResetQuotaUsage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QuotaAdminServer is the server API for QuotaAdmin service.
+// All implementations must embed UnimplementedQuotaAdminServer
+// for forward compatibility
+type QuotaAdminServer interface {
+	GetQuotaUsage(context.Context, *GetQuotaUsageReq) (*GetQuotaUsageResp, error)
+	ResetQuotaUsage(context.Context, *ResetQuotaUsageReq) (*ResetQuotaUsageResp, error)
+	mustEmbedUnimplementedQuotaAdminServer()
+}
+
+// UnimplementedQuotaAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedQuotaAdminServer struct {
+}
+
+func (UnimplementedQuotaAdminServer) GetQuotaUsage(context.Context, *GetQuotaUsageReq) (*GetQuotaUsageResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuotaUsage not implemented")
+}
+func (UnimplementedQuotaAdminServer) ResetQuotaUsage(context.Context, *ResetQuotaUsageReq) (*ResetQuotaUsageResp, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetQuotaUsage not implemented")
+}
+func (UnimplementedQuotaAdminServer) mustEmbedUnimplementedQuotaAdminServer() {}
+
+// UnsafeQuotaAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to QuotaAdminServer will
+// result in compilation errors.
+type UnsafeQuotaAdminServer interface {
+	mustEmbedUnimplementedQuotaAdminServer()
+}
+
+func RegisterQuotaAdminServer(s grpc.ServiceRegistrar, srv QuotaAdminServer) {
+	s.RegisterService(&QuotaAdmin_ServiceDesc, srv)
+}
+
+func _QuotaAdmin_GetQuotaUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQuotaUsageReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QuotaAdminServer).GetQuotaUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QuotaAdmin_GetQuotaUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QuotaAdminServer).GetQuotaUsage(ctx, req.(*GetQuotaUsageReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QuotaAdmin_ResetQuotaUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetQuotaUsageReq)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QuotaAdminServer).ResetQuotaUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QuotaAdmin_ResetQuotaUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QuotaAdminServer).ResetQuotaUsage(ctx, req.(*ResetQuotaUsageReq))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// QuotaAdmin_ServiceDesc is the grpc.ServiceDesc for QuotaAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var QuotaAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "csweb.QuotaAdmin",
+	HandlerType: (*QuotaAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetQuotaUsage",
+			Handler:    _QuotaAdmin_GetQuotaUsage_Handler,
+		},
+		{
+			MethodName: "ResetQuotaUsage",
+			Handler:    _QuotaAdmin_ResetQuotaUsage_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "csweb/quota.proto",
+}